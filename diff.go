@@ -2,15 +2,40 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"diff/archive"
+	"diff/charset"
+	"diff/conflict"
+	"diff/decompress"
+	"diff/dirwalk"
+	"diff/ed"
+	"diff/getopt"
 	"diff/histogramdiff"
+	"diff/lineend"
+	"diff/lineformat"
+	"diff/manifest"
+	"diff/merge"
+	"diff/metadata"
+	"diff/normalize"
 	"diff/patiencediff"
+	"diff/rcs"
+	"diff/region"
+	"diff/tolerance"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/hattya/go.diff"
 	"io"
+	"io/fs"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"runtime"
+	"sort"
 	"strings"
 	"time"
 )
@@ -20,43 +45,285 @@ const EXIT_DIFFERENCE_WERE_FOUND = 1
 const EXIT_AN_ERROR_OCCURRED = 2
 const CONTEXT_DEFAULT = 3
 const NONEWLINE = "No newline at end of file"
+const TABSIZE = 8
+const SIDE_BY_SIDE_GUTTER = 3
 
-//http://pubs.opengroup.org/onlinepubs/9699919799/utilities/diff.html
+// Returned when the errors have already been printed.
+var errreported = errors.New("errors reported")
+
+// http://pubs.opengroup.org/onlinepubs/9699919799/utilities/diff.html
 var flag_b = flag.Bool("b", false, "Ignore changes in amount of white space.")
+var flag_line_endings = flag.String("line-endings", "lf", "Lines end with LF or CRLF (lf), or also with a lone CR (any).")
+var flag_ignore_line_endings = flag.Bool("ignore-line-endings", false, "Ignore differences in line endings.")
 var flag_c = flag.Bool("c", false, "Context diff (three line context).")
 var flag_C = flag.Int("C", 0, "Context diff (specified line context).")
 var flag_e = flag.Bool("e", false, "Ed script diff.")
 var flag_f = flag.Bool("f", false, "Alternative form of ed script diff.")
+var flag_n = flag.Bool("n", false, "RCS format diff.")
+
+var flag_merge = flag.Bool("merge", false, "Three-way merge of MYFILE OLDFILE YOURFILE.")
+var flag_conflict_style = flag.String("conflict-style", "merge", "Conflict style of -merge: merge, diff3 or zdiff3.")
+
+var flag_save_manifest = flag.String("save-manifest", "", "Save a manifest of DIR to FILE, which can be compared like a directory.")
+var flag_manifest_content = flag.Bool("manifest-content", false, "Store the content of text files in the manifest.")
+var flag_conflicts = flag.Bool("conflicts", false, "Report unresolved conflict markers in FILE...")
+
+var flag_interactive = flag.String("interactive", "", "Select hunks interactively and write the selected \"patch\" or the updated \"file\".")
+
+var flag_verify = flag.Bool("verify", false, "Verify that the ed script (-e, -f) turns the first file into the second.")
+var flag_from_file = flag.String("from-file", "", "Compare FILE to each operand.")
+var flag_to_file = flag.String("to-file", "", "Compare each operand to FILE.")
 var flag_r = flag.Bool("r", false, "Compare directory recursively.")
+var flag_encoding = flag.String("encoding", "", "Decode input files from ENCODING, such as shift_jis or utf-16le (default: UTF-8, or the encoding of a byte order mark).")
+var flag_output_encoding = flag.String("output-encoding", "", "Encode the output to ENCODING.")
+var flag_ignore_bom = flag.Bool("ignore-bom", false, "Ignore byte order marks at the beginning of files.")
+var flag_no_decompress = flag.Bool("no-decompress", false, "Do not decompress gzip, bzip2 and zstd compressed files.")
+var flag_no_dereference = flag.Bool("no-dereference", false, "Compare symbolic links as links instead of following them.")
+var flag_ignore_file_name_case = flag.Bool("ignore-file-name-case", false, "Ignore case when comparing file names.")
+var flag_metadata = flag.String("metadata", "", "Also compare the metadata of files in directories: a comma separated list of mode, owner, mtime and xattr.")
+var flag_mtime_tolerance = flag.Duration("mtime-tolerance", 0, "Modification times closer than this compare equal with -metadata.")
+var flag_metadata_format = flag.String("metadata-format", "text", "Report metadata differences as text or json.")
+var flag_j = flag.Int("j", 0, "Compare up to N file pairs in parallel (default: number of CPUs).")
 var flag_u = flag.Bool("u", false, "Unified diff (three line context).")
 var flag_U = flag.Int("U", 0, "Unified diff (specified line context).")
 
+var flag_y = flag.Bool("y", false, "Side by side diff.")
+var flag_W = flag.Int("W", 130, "Output at most NUM columns (side by side).")
+var flag_suppress_common_lines = flag.Bool("suppress-common-lines", false, "Do not print common lines (side by side).")
+var flag_left_column = flag.Bool("left-column", false, "Print only the left column of common lines (side by side).")
+
+var flag_t = flag.Bool("t", false, "Expand tabs to spaces in output.")
+var flag_T = flag.Bool("T", false, "Make tabs line up by printing a tab before the text of a line.")
+var flag_tabsize = flag.Int("tabsize", TABSIZE, "Tab stops are every NUM columns.")
+
+var flag_D = flag.String("D", "", "Output merged file with '#ifdef NAME' diffs.")
+
+var flag_old_line_format = flag.String("old-line-format", "", "Format lines only in the first file.")
+var flag_new_line_format = flag.String("new-line-format", "", "Format lines only in the second file.")
+var flag_unchanged_line_format = flag.String("unchanged-line-format", "", "Format lines common to both files.")
+var flag_line_format = flag.String("line-format", "", "Format all input lines.")
+var flag_old_group_format = flag.String("old-group-format", "", "Format groups of lines only in the first file.")
+var flag_new_group_format = flag.String("new-group-format", "", "Format groups of lines only in the second file.")
+var flag_unchanged_group_format = flag.String("unchanged-group-format", "", "Format groups of lines common to both files.")
+var flag_changed_group_format = flag.String("changed-group-format", "", "Format groups of lines that differ.")
+
 var flag_i = flag.Bool("i", false, "Ignore changes in case of text.")
 
+var flag_abs_tolerance = flag.Float64("abs-tolerance", 0, "Numbers in lines that differ by at most NUM compare equal.")
+var flag_rel_tolerance = flag.Float64("rel-tolerance", 0, "Numbers in lines that differ by at most NUM times the larger magnitude compare equal.")
+
+type normalizers []*normalize.Rule
+
+func (n *normalizers) String() string {
+	l := []string{}
+	for _, r := range *n {
+		l = append(l, r.String())
+	}
+	return strings.Join(l, " ")
+}
+
+func (n *normalizers) Set(value string) error {
+	r, err := normalize.Parse(value)
+	if err != nil {
+		return err
+	}
+	*n = append(*n, r)
+	return nil
+}
+
+var flag_normalize normalizers
+
+func init() {
+	flag.Var(&flag_normalize, "normalize", "Rewrite lines with s/RE/REPLACEMENT/ or a preset (timestamp, uuid, address, tmppath) before comparing them; can be repeated.")
+}
+
+var flag_lines = flag.String("lines", "", "Compare only lines M,N of both files, or M,N:P,Q of each file.")
+var flag_region_begin = flag.String("region-begin", "", "Compare only the lines after the first line matching RE.")
+var flag_region_end = flag.String("region-end", "", "Compare only the lines before the next line matching RE.")
+
+// The regions of the files to compare.
+var aregion region.Region
+var bregion region.Region
+
 var flag_patience = flag.Bool("patience", false, "Patience Diff.")
 var flag_histogram = flag.Bool("histogram", false, "Histogram Diff.")
 
+type labels []string
+
+func (l *labels) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *labels) Set(value string) error {
+	if len(*l) == 2 {
+		return errors.New("too many file label options")
+	}
+	*l = append(*l, value)
+	return nil
+}
+
+var flag_label labels
+var flag_time_style = flag.String("time-style", "gnu", "Print times in the file headers in STYLE: gnu, iso, rfc3339, none or +LAYOUT (a Go time layout).")
+
+func init() {
+	flag.Var(&flag_label, "label", "Use LABEL instead of the file name and time in the headers (given once for each file).")
+}
+
 var flag_utc = flag.Bool("utc", false, "Print time in UTC (for test)")
 
+// Long names of the flags with single letter names, as in GNU diff.  Flags
+// with longer names are also long options.
+var LONGOPTS = []getopt.Alias{
+	{Long: "context", Flag: "c", ArgFlag: "C"},
+	{Long: "ed", Flag: "e"},
+	{Long: "expand-tabs", Flag: "t"},
+	{Long: "forward-ed", Flag: "f"},
+	{Long: "ifdef", ArgFlag: "D"},
+	{Long: "ignore-case", Flag: "i"},
+	{Long: "ignore-space-change", Flag: "b"},
+	{Long: "initial-tab", Flag: "T"},
+	{Long: "jobs", ArgFlag: "j"},
+	{Long: "rcs", Flag: "n"},
+	{Long: "recursive", Flag: "r"},
+	{Long: "side-by-side", Flag: "y"},
+	{Long: "unified", Flag: "u", ArgFlag: "U"},
+	{Long: "width", ArgFlag: "W"},
+}
+
+// Options as parsed, for the command line printed before each diff of -r.
+var options []getopt.Option
+
 func main() {
-	flag.Parse()
+	if cmdname() == "sdiff" {
+		os.Exit(sdiff_main(os.Args[1:]))
+	}
 
-	if flag.NArg() != 2 {
+	operands, err := parseargs(flag.CommandLine, LONGOPTS, os.Args[1:])
+	if err == flag.ErrHelp {
+		flag.Usage()
+		os.Exit(EXIT_NO_DIFFERENCE_WERE_FOUND)
+	} else if err != nil {
+		print_error(fmt.Sprintf("%s", err))
 		flag.Usage()
 		os.Exit(EXIT_AN_ERROR_OCCURRED)
 	}
 
-	difffound, err := run(flag.Arg(0), flag.Arg(1))
+	if *flag_tabsize <= 0 {
+		print_error(fmt.Sprintf("invalid tabsize '%d'", *flag_tabsize))
+		os.Exit(EXIT_AN_ERROR_OCCURRED)
+	}
+	if *flag_abs_tolerance < 0 || *flag_rel_tolerance < 0 {
+		print_error("tolerance must not be negative")
+		os.Exit(EXIT_AN_ERROR_OCCURRED)
+	}
+	if err := setregions(); err != nil {
+		print_error(fmt.Sprintf("%s", err))
+		os.Exit(EXIT_AN_ERROR_OCCURRED)
+	}
+	if _, err := time_layout(""); err != nil {
+		print_error(fmt.Sprintf("%s", err))
+		os.Exit(EXIT_AN_ERROR_OCCURRED)
+	}
+
+	err = setoutputencoding()
 	if err != nil {
 		print_error(fmt.Sprintf("%s", err))
 		os.Exit(EXIT_AN_ERROR_OCCURRED)
 	}
 
-	if difffound {
-		os.Exit(EXIT_DIFFERENCE_WERE_FOUND)
+	if *flag_merge {
+		if len(operands) != 3 {
+			flag.Usage()
+			exit(EXIT_AN_ERROR_OCCURRED)
+		}
+		conflictfound, err := merge3(operands[0], operands[1], operands[2])
+		if err != nil {
+			print_error(fmt.Sprintf("%s", err))
+			exit(EXIT_AN_ERROR_OCCURRED)
+		}
+		if conflictfound {
+			exit(EXIT_DIFFERENCE_WERE_FOUND)
+		} else {
+			exit(EXIT_NO_DIFFERENCE_WERE_FOUND)
+		}
+	}
+
+	if *flag_save_manifest != "" {
+		if len(operands) != 1 {
+			flag.Usage()
+			exit(EXIT_AN_ERROR_OCCURRED)
+		}
+		err := savemanifest(operands[0], *flag_save_manifest)
+		if err != nil {
+			print_error(fmt.Sprintf("%s", err))
+			exit(EXIT_AN_ERROR_OCCURRED)
+		}
+		exit(EXIT_NO_DIFFERENCE_WERE_FOUND)
+	}
+
+	if *flag_conflicts {
+		if len(operands) == 0 {
+			flag.Usage()
+			exit(EXIT_AN_ERROR_OCCURRED)
+		}
+		conflictfound, err := findconflicts(operands)
+		if err != nil {
+			print_error(fmt.Sprintf("%s", err))
+			exit(EXIT_AN_ERROR_OCCURRED)
+		}
+		if conflictfound {
+			exit(EXIT_DIFFERENCE_WERE_FOUND)
+		} else {
+			exit(EXIT_NO_DIFFERENCE_WERE_FOUND)
+		}
+	}
+
+	pairs, err := operandpairs(operands)
+	if err != nil {
+		print_error(fmt.Sprintf("%s", err))
+		exit(EXIT_AN_ERROR_OCCURRED)
+	} else if pairs == nil {
+		flag.Usage()
+		exit(EXIT_AN_ERROR_OCCURRED)
+	}
+
+	code := EXIT_NO_DIFFERENCE_WERE_FOUND
+	for _, pair := range pairs {
+		difffound, err := run(pair[0], pair[1])
+		if err == errreported {
+			code = EXIT_AN_ERROR_OCCURRED
+		} else if err != nil {
+			print_error(fmt.Sprintf("%s", err))
+			code = EXIT_AN_ERROR_OCCURRED
+		} else if difffound && code == EXIT_NO_DIFFERENCE_WERE_FOUND {
+			code = EXIT_DIFFERENCE_WERE_FOUND
+		}
+	}
+	exit(code)
+}
+
+// Return the pairs of operands to compare.  -from-file is compared to each
+// operand and each operand is compared to -to-file, otherwise there must be
+// two operands.  Nil when the operands are wrong.
+func operandpairs(operands []string) ([][2]string, error) {
+	if *flag_from_file != "" && *flag_to_file != "" {
+		return nil, errors.New("--from-file and --to-file both specified")
+	}
+	pairs := [][2]string{}
+	if *flag_from_file != "" {
+		for _, path := range operands {
+			pairs = append(pairs, [2]string{*flag_from_file, path})
+		}
+	} else if *flag_to_file != "" {
+		for _, path := range operands {
+			pairs = append(pairs, [2]string{path, *flag_to_file})
+		}
+	} else if len(operands) == 2 {
+		pairs = append(pairs, [2]string{operands[0], operands[1]})
 	} else {
-		os.Exit(EXIT_NO_DIFFERENCE_WERE_FOUND)
+		return nil, nil
 	}
+	return pairs, nil
 }
 
 func run(apath string, bpath string) (bool, error) {
@@ -64,6 +331,13 @@ func run(apath string, bpath string) (bool, error) {
 		return false, nil
 	}
 
+	for _, path := range []string{apath, bpath} {
+		err := opentree(path)
+		if err != nil {
+			return false, err
+		}
+	}
+
 	aisdir, err := isdir(apath)
 	if err != nil {
 		return false, err
@@ -89,240 +363,697 @@ func run(apath string, bpath string) (bool, error) {
 	}
 }
 
-func diffdir(adir string, bdir string) (bool, error) {
-	afi, err := readdir(adir)
-	if err != nil {
-		return false, err
-	}
-	bfi, err := readdir(bdir)
-	if err != nil {
-		return false, err
+// Merge the changes from opath to apath and from opath to bpath and write
+// the result to stdout.
+func merge3(apath string, opath string, bpath string) (bool, error) {
+	opts := merge.Options{OursLabel: apath, BaseLabel: opath, TheirsLabel: bpath}
+	switch *flag_conflict_style {
+	case "merge":
+		opts.Style = merge.StyleMerge
+	case "diff3":
+		opts.Style = merge.StyleDiff3
+	case "zdiff3":
+		opts.Style = merge.StyleZdiff3
+	default:
+		return false, fmt.Errorf("invalid conflict style '%s'", *flag_conflict_style)
 	}
-	difffound := false
-	a := 0
-	b := 0
-	for a < len(afi) || b < len(bfi) {
-		if a >= len(afi) {
-			fmt.Printf("Only in %s: %s\n", bdir, bfi[b].Name())
-			difffound = true
-			b++
-		} else if b >= len(afi) {
-			fmt.Printf("Only in %s: %s\n", adir, afi[a].Name())
-			difffound = true
-			a++
-		} else if afi[a].Name() < bfi[b].Name() {
-			fmt.Printf("Only in %s: %s\n", adir, afi[a].Name())
-			difffound = true
-			a++
-		} else if afi[a].Name() > bfi[b].Name() {
-			fmt.Printf("Only in %s: %s\n", bdir, bfi[b].Name())
-			difffound = true
-			b++
+	opts.Algorithm = func(al []string, bl []string) []diff.Change {
+		acmp, bcmp := cmpkeys(al, bl)
+		var cl []diff.Change
+		if *flag_patience {
+			cl = patiencediff.Strings(acmp, bcmp)
 		} else {
-			apath := xjoinpath(adir, afi[a].Name())
-			bpath := xjoinpath(bdir, bfi[b].Name())
-			if afi[a].IsDir() && bfi[b].IsDir() {
-				if *flag_r {
-					df, err := diffdir(apath, bpath)
-					if err != nil {
-						return false, err
-					}
-					if df {
-						difffound = true
-					}
-				} else {
-					fmt.Printf("Common subdirectories: %s and %s\n", apath, bpath)
-				}
-			} else if afi[a].IsDir() {
-				fmt.Printf("File %s is a directory while file %s is a regular file\n", apath, bpath)
-				difffound = true
-			} else if bfi[b].IsDir() {
-				fmt.Printf("File %s is a regular file while file %s is a directory\n", apath, bpath)
-				difffound = true
-			} else {
-				head := fmt.Sprintf("%s %s %s\n", reconstructargs(), apath, bpath)
-				df, err := difffile(apath, bpath, head)
-				if err != nil {
-					return false, err
-				}
-				if df {
-					difffound = true
-				}
-			}
-			a++
-			b++
+			cl = histogramdiff.Strings(acmp, bcmp)
 		}
+		return change_compact(cl, acmp, bcmp)
 	}
-	return difffound, nil
-}
 
-func difffile(apath string, bpath string, head string) (bool, error) {
 	al, err := readfile(apath)
 	if err != nil {
 		return false, err
 	}
-
+	ol, err := readfile(opath)
+	if err != nil {
+		return false, err
+	}
 	bl, err := readfile(bpath)
 	if err != nil {
 		return false, err
 	}
 
-	acmp := cmpfilter(al)
-	bcmp := cmpfilter(bl)
-
-	var cl []diff.Change
-	if *flag_histogram {
-		cl = histogramdiff.Strings(acmp, bcmp)
-	} else if *flag_patience {
-		cl = patiencediff.Strings(acmp, bcmp)
-	} else {
-		cl = diff.Strings(acmp, bcmp)
+	r := merge.Merge(ol, al, bl, opts)
+	for _, line := range r.Lines {
+		fmt.Fprint(stdout, line)
 	}
-	cl = change_compact(cl, acmp, bcmp)
+	return len(r.Conflicts) != 0, nil
+}
 
-	if len(cl) != 0 {
-		if head != "" {
-			fmt.Print(head)
+func findconflicts(paths []string) (bool, error) {
+	conflictfound := false
+	for _, path := range paths {
+		lines, err := readfile(path)
+		if err != nil {
+			return false, err
+		}
+		regions, err := conflict.Parse(lines)
+		if err != nil {
+			return false, fmt.Errorf("%s: %s", path, err)
+		}
+		for _, r := range conflict.Conflicts(regions) {
+			fmt.Fprintf(stdout, "%s:%d: unresolved conflict\n", path, r.Start+1)
+			conflictfound = true
 		}
 	}
+	return conflictfound, nil
+}
 
-	if hasflag("C") {
-		if len(cl) != 0 {
-			err := print_context_diff(cl, al, bl, apath, bpath, *flag_C)
-			if err != nil {
-				return false, err
-			}
-		}
-	} else if *flag_c {
-		if len(cl) != 0 {
-			err := print_context_diff(cl, al, bl, apath, bpath, CONTEXT_DEFAULT)
-			if err != nil {
-				return false, err
+// An entry of a directory comparison: either a message, an error message or
+// a pair of files to compare.  An error message does not stop the
+// comparison, but makes it exit with trouble.
+type diritem struct {
+	msg       string
+	difffound bool
+	errmsg    string
+	apath     string
+	bpath     string
+}
+
+type filediff struct {
+	meta      []metadata.Difference
+	identical bool
+	// The files differ, but the content of one is not available.
+	nocontent bool
+	al        []string
+	bl        []string
+	cl        []diff.Change
+	err       error
+}
+
+// Walk both directories first, then compare file pairs on a pool of -j
+// workers.  Results are printed in walk order, so the output is the same as a
+// sequential run.
+func diffdir(adir string, bdir string) (bool, error) {
+	mopts, err := metadataoptions()
+	if err != nil {
+		return false, err
+	}
+	items, walkerr := walkdir(adir, bdir, mopts, []diritem{})
+
+	jobs := *flag_j
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+	results := make([]chan filediff, len(items))
+	for i := range items {
+		results[i] = make(chan filediff, 1)
+	}
+	// A slot is taken before a pair is compared and released when its
+	// result is printed.
+	slots := make(chan bool, jobs)
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		for i, item := range items {
+			if item.msg != "" || item.errmsg != "" {
+				continue
 			}
-		}
-	} else if hasflag("U") {
-		if len(cl) != 0 {
-			err := print_unified_diff(cl, al, bl, apath, bpath, *flag_U)
-			if err != nil {
-				return false, err
+			select {
+			case slots <- true:
+			case <-done:
+				return
 			}
+			go func(i int, item diritem) {
+				results[i] <- comparedirfile(item.apath, item.bpath, mopts)
+			}(i, item)
 		}
-	} else if *flag_u {
-		if len(cl) != 0 {
-			err := print_unified_diff(cl, al, bl, apath, bpath, CONTEXT_DEFAULT)
-			if err != nil {
-				return false, err
+	}()
+
+	difffound := false
+	trouble := false
+	for i, item := range items {
+		if item.errmsg != "" {
+			print_error(item.errmsg)
+			trouble = true
+			continue
+		} else if item.msg != "" {
+			fmt.Fprint(stdout, item.msg)
+			if item.difffound {
+				difffound = true
 			}
+			continue
 		}
-	} else if *flag_e {
-		if len(cl) != 0 {
-			print_ed_diff(cl, al, bl)
-		}
-		if len(al) != 0 && !strings.HasSuffix(al[len(al)-1], "\n") {
-			print_error(fmt.Sprintf("%s: %s\n", apath, NONEWLINE))
-		}
-		if len(bl) != 0 && !strings.HasSuffix(bl[len(bl)-1], "\n") {
-			print_error(fmt.Sprintf("%s: %s\n", bpath, NONEWLINE))
+		r := <-results[i]
+		<-slots
+		if r.err != nil {
+			return false, r.err
 		}
-	} else if *flag_f {
-		if len(cl) != 0 {
-			print_alt_ed_diff(cl, al, bl)
+		if len(r.meta) != 0 {
+			fmt.Fprint(stdout, format_metadata(item.apath, item.bpath, r.meta))
+			difffound = true
 		}
-		if len(al) != 0 && !strings.HasSuffix(al[len(al)-1], "\n") {
-			print_error(fmt.Sprintf("%s: %s\n", apath, NONEWLINE))
+		if r.identical {
+			continue
+		} else if r.nocontent {
+			fmt.Fprintf(stdout, "Files %s and %s differ\n", item.apath, item.bpath)
+			difffound = true
+			continue
 		}
-		if len(bl) != 0 && !strings.HasSuffix(bl[len(bl)-1], "\n") {
-			print_error(fmt.Sprintf("%s: %s\n", bpath, NONEWLINE))
+		head := fmt.Sprintf("%s %s %s\n", reconstructargs(), item.apath, item.bpath)
+		df, err := printdiff(r.al, r.bl, r.cl, item.apath, item.bpath, head)
+		if err != nil {
+			return false, err
 		}
-	} else {
-		if len(cl) != 0 {
-			print_normal_diff(cl, al, bl)
+		if df {
+			difffound = true
 		}
 	}
-
-	return len(cl) != 0, nil
-}
-
-func cmpfilter(lines []string) []string {
-	alt := lines[:]
-	for i, _ := range alt {
-		if *flag_b {
-			re1 := regexp.MustCompile("[ \t\r\n]*$")
-			alt[i] = re1.ReplaceAllString(alt[i], "\n")
-			re2 := regexp.MustCompile("[ \t\r]+")
-			alt[i] = re2.ReplaceAllString(alt[i], " ")
-		}
-		if *flag_i {
-			alt[i] = strings.ToLower(alt[i])
-		}
+	if walkerr != nil {
+		return false, walkerr
+	} else if trouble {
+		return false, errreported
 	}
-	return alt
+	return difffound, nil
 }
 
-func print_normal_diff(cl []diff.Change, al []string, bl []string) {
-	for _, c := range cl {
-		if c.Del == 0 {
-			fmt.Printf("%sa%s\n", format_range_normal(c.A, c.Del), format_range_normal(c.B, c.Ins))
-			for b := c.B; b < c.B+c.Ins; b++ {
-				print_line(fmt.Sprintf("> %s", bl[b]))
+// Append the entries of comparing adir and bdir to items.  On error, the
+// entries found so far are returned with the error.
+func walkdir(adir string, bdir string, mopts *metadata.Options, items []diritem) ([]diritem, error) {
+	opts := dirwalk.Options{FoldCase: *flag_ignore_file_name_case, NoDereference: *flag_no_dereference}
+	afs, err := dirfs(adir)
+	if err != nil {
+		return items, err
+	}
+	bfs, err := dirfs(bdir)
+	if err != nil {
+		return items, err
+	}
+	err = dirwalk.Walk(afs, ".", bfs, ".", opts, func(arel string, brel string, e dirwalk.Entry) error {
+		adirpath := xjoinrel(adir, arel)
+		bdirpath := xjoinrel(bdir, brel)
+		if e.B == nil {
+			items = append(items, diritem{msg: fmt.Sprintf("Only in %s: %s\n", adirpath, e.A.Name()), difffound: true})
+			return nil
+		} else if e.A == nil {
+			items = append(items, diritem{msg: fmt.Sprintf("Only in %s: %s\n", bdirpath, e.B.Name()), difffound: true})
+			return nil
+		}
+		apath := xjoinpath(adirpath, e.A.Name())
+		bpath := xjoinpath(bdirpath, e.B.Name())
+		// Without -no-dereference, a symbolic link is left only when it
+		// is dangling.
+		adangling := !*flag_no_dereference && e.A.Mode()&fs.ModeSymlink != 0
+		bdangling := !*flag_no_dereference && e.B.Mode()&fs.ModeSymlink != 0
+		if adangling || bdangling {
+			if adangling {
+				items = append(items, diritem{errmsg: fmt.Sprintf("%s: No such file or directory", apath)})
 			}
-		} else if c.Ins == 0 {
-			fmt.Printf("%sd%s\n", format_range_normal(c.A, c.Del), format_range_normal(c.B, c.Ins))
-			for a := c.A; a < c.A+c.Del; a++ {
-				print_line(fmt.Sprintf("< %s", al[a]))
+			if bdangling {
+				items = append(items, diritem{errmsg: fmt.Sprintf("%s: No such file or directory", bpath)})
 			}
-		} else {
-			fmt.Printf("%sc%s\n", format_range_normal(c.A, c.Del), format_range_normal(c.B, c.Ins))
-			for a := c.A; a < c.A+c.Del; a++ {
-				print_line(fmt.Sprintf("< %s", al[a]))
+		} else if e.A.IsDir() && e.B.IsDir() {
+			if mopts != nil {
+				item, err := comparedirmetadata(apath, bpath, *mopts)
+				if err != nil {
+					return err
+				}
+				if item.msg != "" {
+					items = append(items, item)
+				}
 			}
-			fmt.Printf("---\n")
-			for b := c.B; b < c.B+c.Ins; b++ {
-				print_line(fmt.Sprintf("> %s", bl[b]))
+			if !*flag_r {
+				items = append(items, diritem{msg: fmt.Sprintf("Common subdirectories: %s and %s\n", apath, bpath)})
+				return fs.SkipDir
+			} else if e.ALoop {
+				items = append(items, diritem{errmsg: fmt.Sprintf("%s: recursive directory loop", apath)})
+			} else if e.BLoop {
+				items = append(items, diritem{errmsg: fmt.Sprintf("%s: recursive directory loop", bpath)})
+			}
+		} else if e.A.Mode().IsRegular() && e.B.Mode().IsRegular() {
+			items = append(items, diritem{apath: apath, bpath: bpath})
+		} else if e.A.Mode()&fs.ModeSymlink != 0 && e.B.Mode()&fs.ModeSymlink != 0 {
+			same, err := samelink(apath, bpath)
+			if err != nil {
+				return err
+			}
+			if !same {
+				items = append(items, diritem{msg: fmt.Sprintf("Symbolic links %s and %s differ\n", apath, bpath), difffound: true})
 			}
+		} else {
+			// Special files are not opened, even of the same type.
+			items = append(items, diritem{msg: fmt.Sprintf("File %s is a %s while file %s is a %s\n", apath, dirwalk.TypeName(e.A), bpath, dirwalk.TypeName(e.B)), difffound: true})
 		}
-	}
+		return nil
+	})
+	return items, err
 }
 
-func format_range_normal(start int, count int) string {
-	base := 1
-	if count == 0 {
-		return fmt.Sprintf("%d", start)
-	} else if count == 1 {
-		return fmt.Sprintf("%d", base+start)
-	} else {
-		return fmt.Sprintf("%d,%d", base+start, base+start+count-1)
+// Symbolic links are compared by the text of their targets.
+func samelink(apath string, bpath string) (bool, error) {
+	atarget, err := readlinkpath(apath)
+	if err != nil {
+		return false, err
+	}
+	btarget, err := readlinkpath(bpath)
+	if err != nil {
+		return false, err
 	}
+	return atarget == btarget, nil
 }
 
-func print_ed_diff(cl []diff.Change, al []string, bl []string) {
-	for i := len(cl) - 1; i >= 0; i-- {
-		c := cl[i]
-		if c.Del == 0 {
-			fmt.Printf("%sa\n", format_range_ed(c.A, c.Del))
-			for b := c.B; b < c.B+c.Ins; b++ {
-				fmt.Printf("%s", bl[b])
-				if !strings.HasSuffix(bl[b], "\n") {
-					fmt.Printf("\n")
-				}
-			}
-			fmt.Printf(".\n")
-		} else if c.Ins == 0 {
-			fmt.Printf("%sd\n", format_range_ed(c.A, c.Del))
+// Identical files are detected without reading them into lines, unless the
+// output format prints identical files too.
+func comparedirfile(apath string, bpath string, mopts *metadata.Options) filediff {
+	var meta []metadata.Difference
+	if mopts != nil {
+		var err error
+		meta, err = comparemetadata(apath, bpath, *mopts)
+		if err != nil {
+			return filediff{err: err}
+		}
+	}
+	if !hascontent(apath) || !hascontent(bpath) {
+		same, err := samedigest(apath, bpath)
+		return filediff{meta: meta, identical: same, nocontent: !same, err: err}
+	}
+	if !(*flag_y || hasflag("D") || hasformatflag()) {
+		same, err := samefile(apath, bpath)
+		if err != nil {
+			return filediff{err: err}
+		}
+		if same {
+			return filediff{meta: meta, identical: true}
+		}
+	}
+	al, bl, cl, err := comparefile(apath, bpath)
+	return filediff{meta: meta, al: al, bl: bl, cl: cl, err: err}
+}
+
+// Parse -metadata.  Nil when metadata is not compared.
+func metadataoptions() (*metadata.Options, error) {
+	if *flag_metadata == "" {
+		return nil, nil
+	}
+	if *flag_metadata_format != "text" && *flag_metadata_format != "json" {
+		return nil, fmt.Errorf("invalid metadata format '%s'", *flag_metadata_format)
+	}
+	opts := metadata.Options{MtimeTolerance: *flag_mtime_tolerance}
+	for _, field := range strings.Split(*flag_metadata, ",") {
+		switch field {
+		case "mode":
+			opts.Mode = true
+		case "owner":
+			opts.Owner = true
+		case "mtime":
+			opts.Mtime = true
+		case "xattr":
+			opts.Xattr = true
+		default:
+			return nil, fmt.Errorf("invalid metadata field '%s'", field)
+		}
+	}
+	return &opts, nil
+}
+
+func comparemetadata(apath string, bpath string, opts metadata.Options) ([]metadata.Difference, error) {
+	a, err := statmetadata(apath, opts.Xattr)
+	if err != nil {
+		return nil, err
+	}
+	b, err := statmetadata(bpath, opts.Xattr)
+	if err != nil {
+		return nil, err
+	}
+	return metadata.Compare(a, b, opts), nil
+}
+
+// Files in a tree have no owner or extended attributes.
+func statmetadata(path string, xattr bool) (metadata.Attr, error) {
+	if _, _, ok := lookuptree(path); ok {
+		fi, err := statpath(path)
+		if err != nil {
+			return metadata.Attr{}, err
+		}
+		return metadata.Info(fi), nil
+	}
+	return metadata.Stat(path, xattr)
+}
+
+func comparedirmetadata(apath string, bpath string, opts metadata.Options) (diritem, error) {
+	dl, err := comparemetadata(apath, bpath, opts)
+	if err != nil || len(dl) == 0 {
+		return diritem{}, err
+	}
+	return diritem{msg: format_metadata(apath, bpath, dl), difffound: true}, nil
+}
+
+// One line for each difference, in the form of -metadata-format.
+func format_metadata(apath string, bpath string, dl []metadata.Difference) string {
+	s := ""
+	for _, d := range dl {
+		if *flag_metadata_format == "json" {
+			b, _ := json.Marshal(struct {
+				A      string `json:"a"`
+				B      string `json:"b"`
+				Field  string `json:"field"`
+				AValue string `json:"a_value"`
+				BValue string `json:"b_value"`
+			}{apath, bpath, d.Field, d.A, d.B})
+			s += string(b) + "\n"
 		} else {
-			fmt.Printf("%sc\n", format_range_ed(c.A, c.Del))
+			s += fmt.Sprintf("Files %s and %s differ in %s: %s != %s\n", apath, bpath, d.Field, d.A, d.B)
+		}
+	}
+	return s
+}
+
+// Compare size, then identity, then content.
+func samefile(apath string, bpath string) (bool, error) {
+	afi, err := statpath(apath)
+	if err != nil {
+		return false, err
+	}
+	bfi, err := statpath(bpath)
+	if err != nil {
+		return false, err
+	}
+	if afi.Size() != bfi.Size() {
+		return false, nil
+	}
+	if os.SameFile(afi, bfi) {
+		return true, nil
+	}
+	af, err := openpath(apath)
+	if err != nil {
+		return false, err
+	}
+	defer af.Close()
+	bf, err := openpath(bpath)
+	if err != nil {
+		return false, err
+	}
+	defer bf.Close()
+	abuf := make([]byte, 64*1024)
+	bbuf := make([]byte, 64*1024)
+	for {
+		an, aerr := io.ReadFull(af, abuf)
+		bn, berr := io.ReadFull(bf, bbuf)
+		if !bytes.Equal(abuf[:an], bbuf[:bn]) {
+			return false, nil
+		}
+		if aerr == io.EOF || aerr == io.ErrUnexpectedEOF {
+			return berr == io.EOF || berr == io.ErrUnexpectedEOF, nil
+		} else if aerr != nil {
+			return false, aerr
+		} else if berr != nil && berr != io.EOF && berr != io.ErrUnexpectedEOF {
+			return false, berr
+		}
+	}
+}
+
+func difffile(apath string, bpath string, head string) (bool, error) {
+	if !hascontent(apath) || !hascontent(bpath) {
+		same, err := samedigest(apath, bpath)
+		if err != nil {
+			return false, err
+		}
+		if !same {
+			fmt.Fprintf(stdout, "Files %s and %s differ\n", apath, bpath)
+		}
+		return !same, nil
+	}
+	al, bl, cl, err := comparefile(apath, bpath)
+	if err != nil {
+		return false, err
+	}
+	return printdiff(al, bl, cl, apath, bpath, head)
+}
+
+// Read and compare two files.  This does not print anything, so it may run
+// in parallel.
+func comparefile(apath string, bpath string) ([]string, []string, []diff.Change, error) {
+	al, err := readfile(apath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	bl, err := readfile(bpath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return al, bl, compute_changes(al, bl), nil
+}
+
+func printdiff(al []string, bl []string, cl []diff.Change, apath string, bpath string, head string) (bool, error) {
+	if hasflag("interactive") {
+		context := CONTEXT_DEFAULT
+		if hasflag("U") {
+			context = *flag_U
+		} else if hasflag("C") {
+			context = *flag_C
+		}
+		err := interactive_diff(cl, al, bl, apath, bpath, context, *flag_interactive)
+		if err != nil {
+			return false, err
+		}
+		return len(cl) != 0, nil
+	}
+
+	if len(cl) != 0 {
+		if head != "" {
+			fmt.Fprint(stdout, head)
+		}
+	}
+
+	// The diff is still printed, so that it converts the line endings.
+	// Scripts are read by programs and get no notice.
+	if len(cl) != 0 && !(*flag_e || *flag_f || *flag_n || hasflag("D") || hasformatflag()) && lineend.OnlyEndingsDiffer(al, bl, linemode()) {
+		fmt.Fprintf(stdout, "Files %s and %s differ only in line endings\n", apath, bpath)
+	}
+
+	if hasflag("C") {
+		if len(cl) != 0 {
+			err := print_context_diff(cl, al, bl, apath, bpath, *flag_C)
+			if err != nil {
+				return false, err
+			}
+		}
+	} else if *flag_c {
+		if len(cl) != 0 {
+			err := print_context_diff(cl, al, bl, apath, bpath, CONTEXT_DEFAULT)
+			if err != nil {
+				return false, err
+			}
+		}
+	} else if hasflag("U") {
+		if len(cl) != 0 {
+			err := print_unified_diff(cl, al, bl, apath, bpath, *flag_U)
+			if err != nil {
+				return false, err
+			}
+		}
+	} else if *flag_u {
+		if len(cl) != 0 {
+			err := print_unified_diff(cl, al, bl, apath, bpath, CONTEXT_DEFAULT)
+			if err != nil {
+				return false, err
+			}
+		}
+	} else if *flag_e {
+		if len(cl) != 0 {
+			print_ed_diff(cl, al, bl)
+		}
+		if *flag_verify {
+			err := verify_ed_diff(ed.Script(cl, bl), ed.Apply, al, bl, bpath)
+			if err != nil {
+				return false, err
+			}
+		}
+		if len(al) != 0 && lineend.Ending(al[len(al)-1], linemode()) == "" {
+			print_error(fmt.Sprintf("%s: %s\n", apath, NONEWLINE))
+		}
+		if len(bl) != 0 && lineend.Ending(bl[len(bl)-1], linemode()) == "" {
+			print_error(fmt.Sprintf("%s: %s\n", bpath, NONEWLINE))
+		}
+	} else if *flag_f {
+		if len(cl) != 0 {
+			print_alt_ed_diff(cl, al, bl)
+		}
+		if *flag_verify {
+			err := verify_ed_diff(ed.AltScript(cl, bl), ed.ApplyAlt, al, bl, bpath)
+			if err != nil {
+				return false, err
+			}
+		}
+		if len(al) != 0 && lineend.Ending(al[len(al)-1], linemode()) == "" {
+			print_error(fmt.Sprintf("%s: %s\n", apath, NONEWLINE))
+		}
+		if len(bl) != 0 && lineend.Ending(bl[len(bl)-1], linemode()) == "" {
+			print_error(fmt.Sprintf("%s: %s\n", bpath, NONEWLINE))
+		}
+	} else if *flag_y {
+		print_side_by_side_diff(region_changes(cl, al, bl))
+	} else if *flag_n {
+		if len(cl) != 0 {
+			print_rcs_diff(cl, al, bl)
+		}
+	} else if hasflag("D") || hasformatflag() {
+		err := print_format_diff(cl, al, bl)
+		if err != nil {
+			return false, err
+		}
+	} else {
+		if len(cl) != 0 {
+			print_normal_diff(cl, al, bl)
+		}
+	}
+
+	return len(cl) != 0, nil
+}
+
+// Compare the regions of al and bl selected by -lines, -region-begin and
+// -region-end.  The changes refer to the lines of al and bl.
+func compute_changes(al []string, bl []string) []diff.Change {
+	astart, aend, bstart, bend := bounds(al, bl)
+	cl := diff_lines(al[astart:aend], bl[bstart:bend])
+	for i := range cl {
+		cl[i].A += astart
+		cl[i].B += bstart
+	}
+	return cl
+}
+
+// Return the regions of al and bl and the changes between them, for the
+// formats that print every line.
+func region_changes(cl []diff.Change, al []string, bl []string) ([]diff.Change, []string, []string) {
+	astart, aend, bstart, bend := bounds(al, bl)
+	rcl := make([]diff.Change, len(cl))
+	for i, c := range cl {
+		c.A -= astart
+		c.B -= bstart
+		rcl[i] = c
+	}
+	return rcl, al[astart:aend], bl[bstart:bend]
+}
+
+func bounds(al []string, bl []string) (int, int, int, int) {
+	astart, aend := aregion.Bounds(al)
+	bstart, bend := bregion.Bounds(bl)
+	return astart, aend, bstart, bend
+}
+
+func diff_lines(al []string, bl []string) []diff.Change {
+	acmp, bcmp := cmpkeys(al, bl)
+
+	var cl []diff.Change
+	if *flag_histogram {
+		cl = histogramdiff.Strings(acmp, bcmp)
+	} else if *flag_patience {
+		cl = patiencediff.Strings(acmp, bcmp)
+	} else {
+		cl = diff.Strings(acmp, bcmp)
+	}
+	return change_compact(cl, acmp, bcmp)
+}
+
+func setregions() error {
+	if *flag_lines != "" {
+		a, b, err := region.ParseLines(*flag_lines)
+		if err != nil {
+			return err
+		}
+		aregion = a
+		bregion = b
+	}
+	begin, err := compile_marker(*flag_region_begin)
+	if err != nil {
+		return err
+	}
+	end, err := compile_marker(*flag_region_end)
+	if err != nil {
+		return err
+	}
+	aregion.Begin, aregion.EndMarker = begin, end
+	bregion.Begin, bregion.EndMarker = begin, end
+	return nil
+}
+
+func compile_marker(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid region marker '%s': %s", expr, err)
+	}
+	return re, nil
+}
+
+// Comparison keys of the lines of both files.
+func cmpkeys(al []string, bl []string) ([]string, []string) {
+	acmp := cmpfilter(al)
+	bcmp := cmpfilter(bl)
+	if *flag_abs_tolerance > 0 || *flag_rel_tolerance > 0 {
+		bcmp = tolerance.Keys(acmp, bcmp, tolerance.Tolerance{Abs: *flag_abs_tolerance, Rel: *flag_rel_tolerance})
+	}
+	return acmp, bcmp
+}
+
+func cmpfilter(lines []string) []string {
+	alt := make([]string, len(lines))
+	copy(alt, lines)
+	for i, _ := range alt {
+		if *flag_ignore_line_endings {
+			alt[i] = lineend.Normalize(alt[i], linemode())
+		}
+		for _, r := range flag_normalize {
+			alt[i] = r.Apply(alt[i])
+		}
+		if *flag_b {
+			// A CR within the line is white space as in GNU diff,
+			// but the line ending is left to -ignore-line-endings.  A
+			// missing one is ignored.
+			end := lineend.Ending(alt[i], linemode())
+			text := strings.TrimRight(alt[i][:len(alt[i])-len(end)], " \t\r")
+			if end == "" {
+				end = "\n"
+			}
+			re := regexp.MustCompile("[ \t\r]+")
+			alt[i] = re.ReplaceAllString(text, " ") + end
+		}
+		if *flag_i {
+			alt[i] = strings.ToLower(alt[i])
+		}
+	}
+	return alt
+}
+
+func print_normal_diff(cl []diff.Change, al []string, bl []string) {
+	for _, c := range cl {
+		if c.Del == 0 {
+			fmt.Fprintf(stdout, "%sa%s\n", format_range_normal(c.A, c.Del), format_range_normal(c.B, c.Ins))
 			for b := c.B; b < c.B+c.Ins; b++ {
-				fmt.Printf("%s", bl[b])
-				if !strings.HasSuffix(bl[b], "\n") {
-					fmt.Printf("\n")
-				}
+				print_text_line("> ", bl[b])
+			}
+		} else if c.Ins == 0 {
+			fmt.Fprintf(stdout, "%sd%s\n", format_range_normal(c.A, c.Del), format_range_normal(c.B, c.Ins))
+			for a := c.A; a < c.A+c.Del; a++ {
+				print_text_line("< ", al[a])
+			}
+		} else {
+			fmt.Fprintf(stdout, "%sc%s\n", format_range_normal(c.A, c.Del), format_range_normal(c.B, c.Ins))
+			for a := c.A; a < c.A+c.Del; a++ {
+				print_text_line("< ", al[a])
+			}
+			fmt.Fprintf(stdout, "---\n")
+			for b := c.B; b < c.B+c.Ins; b++ {
+				print_text_line("> ", bl[b])
 			}
-			fmt.Printf(".\n")
 		}
 	}
 }
 
-func format_range_ed(start int, count int) string {
+func format_range_normal(start int, count int) string {
 	base := 1
 	if count == 0 {
 		return fmt.Sprintf("%d", start)
@@ -333,181 +1064,884 @@ func format_range_ed(start int, count int) string {
 	}
 }
 
+func print_ed_diff(cl []diff.Change, al []string, bl []string) {
+	for _, line := range ed.Script(cl, bl) {
+		fmt.Fprintf(stdout, "%s", expand_tabs(line))
+	}
+}
+
 func print_alt_ed_diff(cl []diff.Change, al []string, bl []string) {
+	for _, line := range ed.AltScript(cl, bl) {
+		fmt.Fprintf(stdout, "%s", expand_tabs(line))
+	}
+}
+
+// Apply the ed script to the first file and check that the result matches
+// the second file.  The script cannot express a missing newline at end of
+// file, so it is ignored.
+func verify_ed_diff(script []string, apply func([]string, []string) ([]string, error), al []string, bl []string, bpath string) error {
+	rl, err := apply(al, script)
+	if err != nil {
+		return fmt.Errorf("ed script verification failed: %s", err)
+	}
+	// The lines outside of the region are not changed by the script.
+	astart, aend, bstart, bend := bounds(al, bl)
+	rl = fixnewline(rl)[astart : len(rl)-(len(al)-aend)]
+	rcmp, bcmp := cmpkeys(rl, fixnewline(bl)[bstart:bend])
+	if !reflect.DeepEqual(rcmp, bcmp) {
+		return fmt.Errorf("ed script verification failed: result differs from %s", bpath)
+	}
+	return nil
+}
+
+func fixnewline(lines []string) []string {
+	r := append([]string{}, lines...)
+	if len(r) != 0 && !strings.HasSuffix(r[len(r)-1], "\n") {
+		r[len(r)-1] += "\n"
+	}
+	return r
+}
+
+func print_side_by_side_diff(cl []diff.Change, al []string, bl []string) {
+	verbose := !*flag_suppress_common_lines
+	a := 0
+	b := 0
 	for _, c := range cl {
-		if c.Del == 0 {
-			fmt.Printf("a%s\n", format_range_alt_ed(c.A, c.Del))
-			for b := c.B; b < c.B+c.Ins; b++ {
-				fmt.Printf("%s", bl[b])
-				if !strings.HasSuffix(bl[b], "\n") {
-					fmt.Printf("\n")
+		print_side_by_side_common(al[a:c.A], bl[b:c.B], verbose)
+		print_side_by_side_change(al[c.A:c.A+c.Del], bl[c.B:c.B+c.Ins])
+		a = c.A + c.Del
+		b = c.B + c.Ins
+	}
+	print_side_by_side_common(al[a:], bl[b:], verbose)
+}
+
+// Print common lines unless verbose is false.
+func print_side_by_side_common(al []string, bl []string, verbose bool) {
+	if !verbose {
+		return
+	}
+	for i := range al {
+		if *flag_left_column {
+			print_side_by_side_line(&al[i], '(', nil)
+		} else {
+			print_side_by_side_line(&al[i], ' ', &bl[i])
+		}
+	}
+}
+
+func print_side_by_side_change(al []string, bl []string) {
+	i := 0
+	for ; i < len(al) && i < len(bl); i++ {
+		print_side_by_side_line(&al[i], '|', &bl[i])
+	}
+	for ; i < len(al); i++ {
+		print_side_by_side_line(&al[i], '<', nil)
+	}
+	for ; i < len(bl); i++ {
+		print_side_by_side_line(nil, '>', &bl[i])
+	}
+}
+
+// Same layout as GNU diff: the left half, a gutter with the separator in the
+// middle, and the right half starting at a tab stop.
+func print_side_by_side_line(left *string, sep byte, right *string) {
+	hw, c2o := side_by_side_columns(*flag_W)
+	var sb strings.Builder
+	col := 0
+	put_newline := false
+	if left != nil {
+		put_newline = put_newline || strings.HasSuffix(*left, "\n")
+		col = print_half_line(&sb, *left, hw)
+	}
+	if sep != ' ' {
+		col = tab_from_to(&sb, col, (hw+c2o-1)/2) + 1
+		if sep == '|' && put_newline != strings.HasSuffix(*right, "\n") {
+			if put_newline {
+				sep = '/'
+			} else {
+				sep = '\\'
+			}
+		}
+		sb.WriteByte(sep)
+	}
+	if right != nil {
+		put_newline = put_newline || strings.HasSuffix(*right, "\n")
+		if *right != "\n" {
+			col = tab_from_to(&sb, col, c2o)
+			print_half_line(&sb, *right, hw)
+		}
+	}
+	if put_newline {
+		sb.WriteByte('\n')
+	}
+	fmt.Fprint(stdout, sb.String())
+}
+
+// Width of each half and column of the right half.
+func side_by_side_columns(width int) (int, int) {
+	t := *flag_tabsize
+	if *flag_t {
+		t = 1
+	}
+	off := (width + t + SIDE_BY_SIDE_GUTTER) / (2 * t) * t
+	hw := off - SIDE_BY_SIDE_GUTTER
+	if width-off < hw {
+		hw = width - off
+	}
+	if hw < 0 {
+		hw = 0
+	}
+	if hw == 0 {
+		return 0, width
+	}
+	return hw, off
+}
+
+// Print line truncated to bound columns and return the last column.
+func print_half_line(sb *strings.Builder, line string, bound int) int {
+	in := 0
+	out := 0
+	for _, r := range strings.TrimSuffix(line, "\n") {
+		if r == '\t' {
+			spaces := *flag_tabsize - in%*flag_tabsize
+			if in == out {
+				tabstop := out + spaces
+				if *flag_t {
+					if bound < tabstop {
+						tabstop = bound
+					}
+					for ; out < tabstop; out++ {
+						sb.WriteByte(' ')
+					}
+				} else if tabstop < bound {
+					out = tabstop
+					sb.WriteRune(r)
 				}
 			}
-			fmt.Printf(".\n")
-		} else if c.Ins == 0 {
-			fmt.Printf("d%s\n", format_range_alt_ed(c.A, c.Del))
+			in += spaces
 		} else {
-			fmt.Printf("c%s\n", format_range_alt_ed(c.A, c.Del))
-			for b := c.B; b < c.B+c.Ins; b++ {
-				fmt.Printf("%s", bl[b])
-				if !strings.HasSuffix(bl[b], "\n") {
-					fmt.Printf("\n")
+			if in < bound {
+				out = in + 1
+				sb.WriteRune(r)
+			}
+			in++
+		}
+	}
+	return out
+}
+
+func tab_from_to(sb *strings.Builder, from int, to int) int {
+	if !*flag_t {
+		tabsize := *flag_tabsize
+		for tab := from + tabsize - from%tabsize; tab <= to; tab += tabsize - tab%tabsize {
+			sb.WriteByte('\t')
+			from = tab
+		}
+	}
+	for ; from < to; from++ {
+		sb.WriteByte(' ')
+	}
+	return to
+}
+
+func print_rcs_diff(cl []diff.Change, al []string, bl []string) {
+	for _, line := range rcs.Delta(cl, bl) {
+		fmt.Fprintf(stdout, "%s", expand_tabs(line))
+	}
+}
+
+// Output formatted by line and group formats.  -D NAME is a set of group
+// formats producing a merged file with #ifdef/#ifndef/#else around the
+// differences.
+func print_format_diff(cl []diff.Change, al []string, bl []string) error {
+	if *flag_t {
+		al = expand_lines(al)
+		bl = expand_lines(bl)
+	}
+	f := lineformat.Default()
+	if hasflag("D") {
+		f = lineformat.Ifdef(*flag_D)
+	}
+	if hasflag("line-format") {
+		f.OldLine = *flag_line_format
+		f.NewLine = *flag_line_format
+		f.UnchangedLine = *flag_line_format
+	}
+	if hasflag("old-line-format") {
+		f.OldLine = *flag_old_line_format
+	}
+	if hasflag("new-line-format") {
+		f.NewLine = *flag_new_line_format
+	}
+	if hasflag("unchanged-line-format") {
+		f.UnchangedLine = *flag_unchanged_line_format
+	}
+	if hasflag("old-group-format") {
+		f.OldGroup = *flag_old_group_format
+	}
+	if hasflag("new-group-format") {
+		f.NewGroup = *flag_new_group_format
+	}
+	if hasflag("unchanged-group-format") {
+		f.UnchangedGroup = *flag_unchanged_group_format
+	}
+	if hasflag("changed-group-format") {
+		f.ChangedGroup = *flag_changed_group_format
+	}
+	astart, aend, bstart, bend := bounds(al, bl)
+	return lineformat.WriteLines(stdout, cl, al, astart, aend, bl, bstart, bend, f)
+}
+
+func hasformatflag() bool {
+	for _, name := range []string{"line-format", "old-line-format", "new-line-format", "unchanged-line-format", "old-group-format", "new-group-format", "unchanged-group-format", "changed-group-format"} {
+		if hasflag(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func print_context_diff(cl []diff.Change, al []string, bl []string, apath string, bpath string, context int) error {
+	err := print_context_head(apath, bpath)
+	if err != nil {
+		return err
+	}
+	cstart := 0
+	for cstart < len(cl) {
+		cend, astart, acount, bstart, bcount := make_hunk(cl, cstart, len(al), len(bl), context)
+		fmt.Fprintf(stdout, "***************\n")
+		fmt.Fprintf(stdout, "*** %s ****\n", format_range_context(astart, acount))
+		hasdel := false
+		hasins := false
+		for _, c := range cl[cstart : cend+1] {
+			if c.Del != 0 {
+				hasdel = true
+			}
+			if c.Ins != 0 {
+				hasins = true
+			}
+		}
+		if hasdel {
+			a := astart
+			for _, c := range cl[cstart : cend+1] {
+				for ; a < c.A; a++ {
+					print_text_line("  ", al[a])
+				}
+				for ; a < c.A+c.Del; a++ {
+					if c.Ins == 0 {
+						print_text_line("- ", al[a])
+					} else {
+						print_text_line("! ", al[a])
+					}
+				}
+			}
+			for ; a < astart+acount; a++ {
+				print_text_line("  ", al[a])
+			}
+		}
+		fmt.Fprintf(stdout, "--- %s ----\n", format_range_context(bstart, bcount))
+		if hasins {
+			b := bstart
+			for _, c := range cl[cstart : cend+1] {
+				for ; b < c.B; b++ {
+					print_text_line("  ", bl[b])
+				}
+				for ; b < c.B+c.Ins; b++ {
+					if c.Del == 0 {
+						print_text_line("+ ", bl[b])
+					} else {
+						print_text_line("! ", bl[b])
+					}
 				}
 			}
-			fmt.Printf(".\n")
+			for ; b < bstart+bcount; b++ {
+				print_text_line("  ", bl[b])
+			}
 		}
+		cstart = cend + 1
 	}
+	return nil
 }
 
-func format_range_alt_ed(start int, count int) string {
+func print_context_head(apath string, bpath string) error {
+	return print_head("***", apath, "---", bpath, "Mon Jan _2 15:04:05 2006")
+}
+
+func format_range_context(start int, count int) string {
 	base := 1
 	if count == 0 {
 		return fmt.Sprintf("%d", start)
 	} else if count == 1 {
 		return fmt.Sprintf("%d", base+start)
 	} else {
-		return fmt.Sprintf("%d %d", base+start, base+start+count-1)
+		return fmt.Sprintf("%d,%d", base+start, base+start+count-1)
+	}
+}
+
+func print_unified_diff(cl []diff.Change, al []string, bl []string, apath string, bpath string, context int) error {
+	err := print_unified_head(apath, bpath)
+	if err != nil {
+		return err
+	}
+	for _, h := range make_hunks(cl, len(al), len(bl), context) {
+		for i, line := range unified_hunk(h, al, bl) {
+			if i == 0 {
+				print_line(line)
+			} else {
+				print_text_line(line[:1], line[1:])
+			}
+		}
+	}
+	return nil
+}
+
+func unified_hunk(h hunk, al []string, bl []string) []string {
+	lines := []string{}
+	lines = append(lines, fmt.Sprintf("@@ -%s +%s @@\n", format_range_unified(h.astart, h.acount), format_range_unified(h.bstart, h.bcount)))
+	a := h.astart
+	for _, c := range h.cl {
+		for ; a < c.A; a++ {
+			lines = append(lines, fmt.Sprintf(" %s", al[a]))
+		}
+		for ; a < c.A+c.Del; a++ {
+			lines = append(lines, fmt.Sprintf("-%s", al[a]))
+		}
+		for b := c.B; b < c.B+c.Ins; b++ {
+			lines = append(lines, fmt.Sprintf("+%s", bl[b]))
+		}
+	}
+	for ; a < h.astart+h.acount; a++ {
+		lines = append(lines, fmt.Sprintf(" %s", al[a]))
+	}
+	return lines
+}
+
+const INTERACTIVE_HELP = `y - accept this hunk
+n - skip this hunk
+a - accept this hunk and all later hunks
+d - skip this hunk and all later hunks
+s - split the current hunk into smaller hunks
+e - manually edit the current hunk
+q - quit; skip this hunk and all later hunks
+? - print help
+`
+
+// Replace del lines at a of the first file with lines.
+type edit struct {
+	a     int
+	del   int
+	lines []string
+}
+
+// Walk the hunks like "git add -p".  Hunks are shown and prompted on stderr
+// and answers are read from stdin, one per line.  The selected hunks are
+// written to stdout as a unified diff, or as the updated first file.
+func interactive_diff(cl []diff.Change, al []string, bl []string, apath string, bpath string, context int, mode string) error {
+	if mode != "patch" && mode != "file" {
+		return fmt.Errorf("invalid -interactive mode '%s'", mode)
+	}
+	if apath == "-" || bpath == "-" {
+		return fmt.Errorf("%s", "cannot read '-' with -interactive")
+	}
+	hunks := make_hunks(cl, len(al), len(bl), context)
+	edits := []edit{}
+	in := bufio.NewReader(os.Stdin)
+	all := ""
+	for i := 0; i < len(hunks); i++ {
+		h := hunks[i]
+		if all == "a" {
+			edits = append(edits, hunk_edits(h, bl)...)
+			continue
+		} else if all == "d" {
+			break
+		}
+		for _, line := range unified_hunk(h, al, bl) {
+			fmt.Fprint(os.Stderr, format_line(line))
+		}
+		done := false
+		for !done {
+			choices := "y,n,a,d"
+			if len(h.cl) > 1 {
+				choices += ",s"
+			}
+			choices += ",e,q,?"
+			fmt.Fprintf(os.Stderr, "(%d/%d) Accept this hunk [%s]? ", i+1, len(hunks), choices)
+			answer, err := in.ReadString('\n')
+			if err != nil && err != io.EOF {
+				return err
+			}
+			answer = strings.TrimSpace(answer)
+			if err == io.EOF && answer == "" {
+				fmt.Fprintf(os.Stderr, "\n")
+				answer = "q"
+			}
+			done = true
+			switch {
+			case answer == "y":
+				edits = append(edits, hunk_edits(h, bl)...)
+			case answer == "n":
+			case answer == "a":
+				edits = append(edits, hunk_edits(h, bl)...)
+				all = "a"
+			case answer == "d" || answer == "q":
+				all = "d"
+			case answer == "s" && len(h.cl) > 1:
+				split := split_hunk(h, context)
+				fmt.Fprintf(os.Stderr, "Split into %d hunks.\n", len(split))
+				hunks = append(hunks[:i], append(split, hunks[i+1:]...)...)
+				i--
+			case answer == "e":
+				e, ok, err := edit_hunk(h, al, bl)
+				if err != nil {
+					return err
+				}
+				if ok {
+					edits = append(edits, e)
+				} else {
+					fmt.Fprintf(os.Stderr, "Your edited hunk does not apply.\n")
+					done = false
+				}
+			default:
+				fmt.Fprint(os.Stderr, INTERACTIVE_HELP)
+				done = false
+			}
+		}
+	}
+	ml, err := apply_edits(al, edits)
+	if err != nil {
+		return err
+	}
+	if mode == "file" {
+		for _, line := range ml {
+			fmt.Fprint(stdout, line)
+		}
+		return nil
+	}
+	mcl := diff_lines(al, ml)
+	if len(mcl) == 0 {
+		return nil
+	}
+	return print_unified_diff(mcl, al, ml, apath, bpath, context)
+}
+
+func hunk_edits(h hunk, bl []string) []edit {
+	edits := []edit{}
+	for _, c := range h.cl {
+		edits = append(edits, edit{c.A, c.Del, bl[c.B : c.B+c.Ins]})
+	}
+	return edits
+}
+
+// Split a hunk into one hunk per change.  Context lines are shared between
+// neighbouring hunks but never include lines of another change.
+func split_hunk(h hunk, context int) []hunk {
+	hunks := []hunk{}
+	for i, c := range h.cl {
+		astart := c.A - context
+		bstart := c.B - context
+		aend := c.A + c.Del + context
+		bend := c.B + c.Ins + context
+		if i == 0 {
+			astart = h.astart
+			bstart = h.bstart
+		} else if prev := h.cl[i-1]; astart < prev.A+prev.Del {
+			astart = prev.A + prev.Del
+			bstart = prev.B + prev.Ins
+		}
+		if i == len(h.cl)-1 {
+			aend = h.astart + h.acount
+			bend = h.bstart + h.bcount
+		} else if next := h.cl[i+1]; aend > next.A {
+			aend = next.A
+			bend = next.B
+		}
+		hunks = append(hunks, hunk{h.cl[i : i+1], astart, aend - astart, bstart, bend - bstart})
+	}
+	return hunks
+}
+
+// Let the user edit the hunk with $EDITOR.  ok is false when the edited hunk
+// does not match the first file.
+func edit_hunk(h hunk, al []string, bl []string) (e edit, ok bool, err error) {
+	f, err := ioutil.TempFile("", "diff-hunk-")
+	if err != nil {
+		return edit{}, false, err
+	}
+	defer os.Remove(f.Name())
+	fmt.Fprintf(f, "# Manual hunk edit mode -- see bottom for a quick guide.\n")
+	for _, line := range unified_hunk(h, al, bl) {
+		fmt.Fprint(f, format_line(line))
+	}
+	fmt.Fprintf(f, "# ---\n")
+	fmt.Fprintf(f, "# To remove '-' lines, make them ' ' lines (context).\n")
+	fmt.Fprintf(f, "# To remove '+' lines, delete them.\n")
+	fmt.Fprintf(f, "# Lines starting with # will be removed.\n")
+	err = f.Close()
+	if err != nil {
+		return edit{}, false, err
+	}
+	err = run_editor(f.Name())
+	if err != nil {
+		return edit{}, false, err
+	}
+	lines, err := readfile(f.Name())
+	if err != nil {
+		return edit{}, false, err
+	}
+	oldl := []string{}
+	newl := []string{}
+	var last byte
+	for _, line := range lines {
+		if line == "\n" {
+			line = " \n"
+		}
+		switch line[0] {
+		case '#', '@':
+			continue
+		case ' ':
+			oldl = append(oldl, line[1:])
+			newl = append(newl, line[1:])
+		case '-':
+			oldl = append(oldl, line[1:])
+		case '+':
+			newl = append(newl, line[1:])
+		case '\\':
+			if last == ' ' || last == '-' {
+				oldl[len(oldl)-1] = strings.TrimSuffix(oldl[len(oldl)-1], "\n")
+			}
+			if last == ' ' || last == '+' {
+				newl[len(newl)-1] = strings.TrimSuffix(newl[len(newl)-1], "\n")
+			}
+		default:
+			return edit{}, false, nil
+		}
+		last = line[0]
+	}
+	if !reflect.DeepEqual(oldl, al[h.astart:h.astart+h.acount]) {
+		return edit{}, false, nil
+	}
+	// Drop unchanged context so that edits of neighbouring hunks do not
+	// overlap.
+	a := h.astart
+	for len(oldl) != 0 && len(newl) != 0 && oldl[0] == newl[0] {
+		oldl = oldl[1:]
+		newl = newl[1:]
+		a++
+	}
+	for len(oldl) != 0 && len(newl) != 0 && oldl[len(oldl)-1] == newl[len(newl)-1] {
+		oldl = oldl[:len(oldl)-1]
+		newl = newl[:len(newl)-1]
+	}
+	return edit{a, len(oldl), newl}, true, nil
+}
+
+func apply_edits(al []string, edits []edit) ([]string, error) {
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].a < edits[j].a })
+	ml := []string{}
+	a := 0
+	for _, e := range edits {
+		if e.a < a {
+			return nil, fmt.Errorf("%s", "edited hunks overlap")
+		}
+		ml = append(ml, al[a:e.a]...)
+		ml = append(ml, e.lines...)
+		a = e.a + e.del
+	}
+	ml = append(ml, al[a:]...)
+	return ml, nil
+}
+
+const SDIFF_HELP = `b:	Use both versions.
+e:	Discard both versions then edit a new one.
+eb:	Edit then use both versions.
+el or e1:	Edit then use the left version.
+er or e2:	Edit then use the right version.
+l or 1:	Use the left version.
+r or 2:	Use the right version.
+s:	Silently include common lines.
+v:	Verbosely include common lines.
+q:	Quit; use the left version for this and all remaining differences.
+`
+
+// Comparison flags shared by diff and sdiff.
+var CMPFLAGS = []string{"b", "i", "line-endings", "ignore-line-endings", "patience", "histogram", "abs-tolerance", "rel-tolerance", "normalize", "lines", "region-begin", "region-end", "encoding", "ignore-bom", "no-decompress"}
+
+// Long names of the single letter flags of sdiff.
+var SDIFF_LONGOPTS = []getopt.Alias{
+	{Long: "expand-tabs", Flag: "t"},
+	{Long: "ignore-case", Flag: "i"},
+	{Long: "ignore-space-change", Flag: "b"},
+	{Long: "left-column", Flag: "l"},
+	{Long: "output", ArgFlag: "o"},
+	{Long: "suppress-common-lines", Flag: "s"},
+	{Long: "width", ArgFlag: "w"},
+}
+
+func sdiff_main(args []string) int {
+	fs := flag.NewFlagSet(cmdname(), flag.ExitOnError)
+	output := fs.String("o", "", "Merge interactively and write the result to FILE.")
+	fs.BoolVar(flag_suppress_common_lines, "s", false, "Do not print common lines.")
+	fs.IntVar(flag_W, "w", 130, "Output at most NUM columns.")
+	fs.BoolVar(flag_left_column, "l", false, "Print only the left column of common lines.")
+	fs.BoolVar(flag_t, "t", false, "Expand tabs to spaces in output.")
+	fs.IntVar(flag_tabsize, "tabsize", TABSIZE, "Tab stops are every NUM columns.")
+	for _, name := range CMPFLAGS {
+		f := flag.Lookup(name)
+		fs.Var(f.Value, f.Name, f.Usage)
+	}
+	operands, err := parseargs(fs, SDIFF_LONGOPTS, args)
+	if err == flag.ErrHelp {
+		fs.Usage()
+		return EXIT_NO_DIFFERENCE_WERE_FOUND
+	} else if err != nil || len(operands) != 2 {
+		if err != nil {
+			print_error(fmt.Sprintf("%s", err))
+		}
+		fs.Usage()
+		return EXIT_AN_ERROR_OCCURRED
+	}
+	if *flag_tabsize <= 0 {
+		print_error(fmt.Sprintf("invalid tabsize '%d'", *flag_tabsize))
+		return EXIT_AN_ERROR_OCCURRED
+	}
+	if err := setregions(); err != nil {
+		print_error(fmt.Sprintf("%s", err))
+		return EXIT_AN_ERROR_OCCURRED
+	}
+
+	difffound, err := sdiff(operands[0], operands[1], *output)
+	if err != nil {
+		print_error(fmt.Sprintf("%s", err))
+		return EXIT_AN_ERROR_OCCURRED
+	}
+
+	if difffound {
+		return EXIT_DIFFERENCE_WERE_FOUND
+	} else {
+		return EXIT_NO_DIFFERENCE_WERE_FOUND
+	}
+}
+
+func sdiff(apath string, bpath string, output string) (bool, error) {
+	if output != "" && (apath == "-" || bpath == "-") {
+		return false, fmt.Errorf("%s", "cannot read '-' with -o")
+	}
+	aisdir, err := isdir(apath)
+	if err != nil {
+		return false, err
+	}
+	bisdir, err := isdir(bpath)
+	if err != nil {
+		return false, err
+	}
+	if aisdir || bisdir {
+		return false, fmt.Errorf("%s", "cannot compare directories")
+	}
+
+	al, err := readfile(apath)
+	if err != nil {
+		return false, err
+	}
+	bl, err := readfile(bpath)
+	if err != nil {
+		return false, err
+	}
+
+	cl := compute_changes(al, bl)
+	rcl, ral, rbl := region_changes(cl, al, bl)
+
+	if output == "" {
+		print_side_by_side_diff(rcl, ral, rbl)
+		return len(cl) != 0, nil
 	}
-}
 
-func print_context_diff(cl []diff.Change, al []string, bl []string, apath string, bpath string, context int) error {
-	err := print_context_head(apath, bpath)
+	// The lines of the first file outside of the region are copied as they
+	// are.
+	rml, err := sdiff_merge(rcl, ral, rbl)
 	if err != nil {
-		return err
+		return false, err
 	}
-	cstart := 0
-	for cstart < len(cl) {
-		cend, astart, acount, bstart, bcount := make_hunk(cl, cstart, len(al), len(bl), context)
-		fmt.Printf("***************\n")
-		fmt.Printf("*** %s ****\n", format_range_context(astart, acount))
-		hasdel := false
-		hasins := false
-		for _, c := range cl[cstart : cend+1] {
-			if c.Del != 0 {
-				hasdel = true
-			}
-			if c.Ins != 0 {
-				hasins = true
-			}
+	astart, aend, _, _ := bounds(al, bl)
+	ml := append(append(append([]string{}, al[:astart]...), rml...), al[aend:]...)
+	err = ioutil.WriteFile(output, []byte(strings.Join(ml, "")), 0666)
+	if err != nil {
+		return false, err
+	}
+	return len(cl) != 0, nil
+}
+
+// Show each difference side by side and ask which version goes into the
+// merged output.  Prompts go to stdout and answers are read from stdin, one
+// per line.
+func sdiff_merge(cl []diff.Change, al []string, bl []string) ([]string, error) {
+	ml := []string{}
+	in := bufio.NewReader(os.Stdin)
+	verbose := !*flag_suppress_common_lines
+	quit := false
+	a := 0
+	b := 0
+	for _, c := range cl {
+		if !quit {
+			print_side_by_side_common(al[a:c.A], bl[b:c.B], verbose)
 		}
-		if hasdel {
-			a := astart
-			for _, c := range cl[cstart : cend+1] {
-				for ; a < c.A; a++ {
-					print_line(fmt.Sprintf("  %s", al[a]))
-				}
-				for ; a < c.A+c.Del; a++ {
-					if c.Ins == 0 {
-						print_line(fmt.Sprintf("- %s", al[a]))
-					} else {
-						print_line(fmt.Sprintf("! %s", al[a]))
-					}
-				}
+		ml = append(ml, al[a:c.A]...)
+		left := al[c.A : c.A+c.Del]
+		right := bl[c.B : c.B+c.Ins]
+		a = c.A + c.Del
+		b = c.B + c.Ins
+		if quit {
+			ml = append(ml, left...)
+			continue
+		}
+		print_side_by_side_change(left, right)
+		for done := false; !done; {
+			fmt.Fprintf(stdout, "%% ")
+			answer, err := in.ReadString('\n')
+			if err != nil && err != io.EOF {
+				return nil, err
 			}
-			for ; a < astart+acount; a++ {
-				print_line(fmt.Sprintf("  %s", al[a]))
+			answer = strings.TrimSpace(answer)
+			if err == io.EOF && answer == "" {
+				fmt.Fprintf(stdout, "\n")
+				answer = "q"
 			}
-		}
-		fmt.Printf("--- %s ----\n", format_range_context(bstart, bcount))
-		if hasins {
-			b := bstart
-			for _, c := range cl[cstart : cend+1] {
-				for ; b < c.B; b++ {
-					print_line(fmt.Sprintf("  %s", bl[b]))
+			done = true
+			switch answer {
+			case "l", "1":
+				ml = append(ml, left...)
+			case "r", "2":
+				ml = append(ml, right...)
+			case "b":
+				ml = append(ml, left...)
+				ml = append(ml, right...)
+			case "e", "el", "e1", "er", "e2", "eb":
+				t := []string{}
+				if answer != "e" && answer != "er" && answer != "e2" {
+					t = append(t, left...)
 				}
-				for ; b < c.B+c.Ins; b++ {
-					if c.Del == 0 {
-						print_line(fmt.Sprintf("+ %s", bl[b]))
-					} else {
-						print_line(fmt.Sprintf("! %s", bl[b]))
-					}
+				if answer != "e" && answer != "el" && answer != "e1" {
+					t = append(t, right...)
 				}
-			}
-			for ; b < bstart+bcount; b++ {
-				print_line(fmt.Sprintf("  %s", bl[b]))
+				t, err := edit_lines(t)
+				if err != nil {
+					return nil, err
+				}
+				ml = append(ml, t...)
+			case "s":
+				verbose = false
+				done = false
+			case "v":
+				verbose = true
+				done = false
+			case "q":
+				ml = append(ml, left...)
+				quit = true
+			default:
+				fmt.Fprint(stdout, SDIFF_HELP)
+				done = false
 			}
 		}
-		cstart = cend + 1
 	}
-	return nil
+	if !quit {
+		print_side_by_side_common(al[a:], bl[b:], verbose)
+	}
+	ml = append(ml, al[a:]...)
+	return ml, nil
 }
 
-func print_context_head(apath string, bpath string) error {
-	amodtime, err := fmodtime(apath)
+// Let the user edit lines with $EDITOR.
+func edit_lines(lines []string) ([]string, error) {
+	f, err := ioutil.TempFile("", "diff-edit-")
 	if err != nil {
-		return err
+		return nil, err
 	}
-	bmodtime, err := fmodtime(bpath)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(strings.Join(lines, ""))
 	if err != nil {
-		return err
+		f.Close()
+		return nil, err
 	}
-	if *flag_utc {
-		fmt.Printf("*** %s\t%s\n", apath, amodtime.UTC().Format("Mon Jan _2 15:04:05 2006"))
-		fmt.Printf("--- %s\t%s\n", bpath, bmodtime.UTC().Format("Mon Jan _2 15:04:05 2006"))
-	} else {
-		fmt.Printf("*** %s\t%s\n", apath, amodtime.Format("Mon Jan _2 15:04:05 2006"))
-		fmt.Printf("--- %s\t%s\n", bpath, bmodtime.Format("Mon Jan _2 15:04:05 2006"))
+	err = f.Close()
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	err = run_editor(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	return readfile(f.Name())
 }
 
-func format_range_context(start int, count int) string {
-	base := 1
-	if count == 0 {
-		return fmt.Sprintf("%d", start)
-	} else if count == 1 {
-		return fmt.Sprintf("%d", base+start)
-	} else {
-		return fmt.Sprintf("%d,%d", base+start, base+start+count-1)
+func run_editor(path string) error {
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
 	}
+	args := strings.Fields(editor)
+	cmd := exec.Command(args[0], append(args[1:], path)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
 }
 
-func print_unified_diff(cl []diff.Change, al []string, bl []string, apath string, bpath string, context int) error {
-	err := print_unified_head(apath, bpath)
+func print_unified_head(apath string, bpath string) error {
+	return print_head("---", apath, "+++", bpath, "2006-01-02 15:04:05.000000000 -0700")
+}
+
+// Print the file header lines.  The labels of -label replace the names and
+// the times, and -time-style overrides layout.
+func print_head(amark string, apath string, bmark string, bpath string, layout string) error {
+	alabel, err := head_label(apath, 0, layout)
 	if err != nil {
 		return err
 	}
-	cstart := 0
-	for cstart < len(cl) {
-		cend, astart, acount, bstart, bcount := make_hunk(cl, cstart, len(al), len(bl), context)
-		fmt.Printf("@@ -%s +%s @@\n", format_range_unified(astart, acount), format_range_unified(bstart, bcount))
-		a := astart
-		for _, c := range cl[cstart : cend+1] {
-			for ; a < c.A; a++ {
-				print_line(fmt.Sprintf(" %s", al[a]))
-			}
-			for ; a < c.A+c.Del; a++ {
-				print_line(fmt.Sprintf("-%s", al[a]))
-			}
-			for b := c.B; b < c.B+c.Ins; b++ {
-				print_line(fmt.Sprintf("+%s", bl[b]))
-			}
-		}
-		for ; a < astart+acount; a++ {
-			print_line(fmt.Sprintf(" %s", al[a]))
-		}
-		cstart = cend + 1
+	blabel, err := head_label(bpath, 1, layout)
+	if err != nil {
+		return err
 	}
+	fmt.Fprintf(stdout, "%s %s\n", amark, alabel)
+	fmt.Fprintf(stdout, "%s %s\n", bmark, blabel)
 	return nil
 }
 
-func print_unified_head(apath string, bpath string) error {
-	amodtime, err := fmodtime(apath)
+func head_label(path string, i int, layout string) (string, error) {
+	if i < len(flag_label) {
+		return flag_label[i], nil
+	}
+	layout, err := time_layout(layout)
 	if err != nil {
-		return err
+		return "", err
+	} else if layout == "" {
+		return path, nil
 	}
-	bmodtime, err := fmodtime(bpath)
+	modtime, err := fmodtime(path)
 	if err != nil {
-		return err
+		return "", err
 	}
 	if *flag_utc {
-		fmt.Printf("--- %s\t%s\n", apath, amodtime.UTC().Format("2006-01-02 15:04:05.000000000 -0700"))
-		fmt.Printf("+++ %s\t%s\n", bpath, bmodtime.UTC().Format("2006-01-02 15:04:05.000000000 -0700"))
-	} else {
-		fmt.Printf("--- %s\t%s\n", apath, amodtime.Format("2006-01-02 15:04:05.000000000 -0700"))
-		fmt.Printf("+++ %s\t%s\n", bpath, bmodtime.Format("2006-01-02 15:04:05.000000000 -0700"))
+		modtime = modtime.UTC()
 	}
-	return nil
+	return fmt.Sprintf("%s\t%s", path, modtime.Format(layout)), nil
+}
+
+// Return the time layout of -time-style, or "" for none.  The gnu style uses
+// the default layout of the output format.
+func time_layout(gnu string) (string, error) {
+	style := *flag_time_style
+	switch {
+	case style == "gnu":
+		return gnu, nil
+	case style == "none":
+		return "", nil
+	case style == "iso":
+		return "2006-01-02 15:04:05 -0700", nil
+	case style == "rfc3339":
+		return time.RFC3339Nano, nil
+	case strings.HasPrefix(style, "+") && len(style) > 1:
+		return style[1:], nil
+	}
+	return "", fmt.Errorf("invalid time style '%s'", style)
 }
 
 func format_range_unified(start int, count int) string {
@@ -522,10 +1956,51 @@ func format_range_unified(start int, count int) string {
 }
 
 func print_line(line string) {
-	fmt.Print(line)
-	if !strings.HasSuffix(line, "\n") {
-		fmt.Printf("\n\\ %s\n", NONEWLINE)
+	fmt.Fprint(stdout, format_line(line))
+}
+
+// Print a line of a file after its prefix, applying -T and -t.
+func print_text_line(prefix string, line string) {
+	if *flag_T {
+		prefix = strings.TrimSuffix(prefix, " ") + "\t"
+	}
+	print_line(prefix + expand_tabs(line))
+}
+
+// Expand tabs to spaces with -t.  Columns are counted from the beginning of
+// line.
+func expand_tabs(line string) string {
+	if !*flag_t || strings.IndexByte(line, '\t') == -1 {
+		return line
+	}
+	var sb strings.Builder
+	col := 0
+	for _, r := range line {
+		if r == '\t' {
+			n := *flag_tabsize - col%*flag_tabsize
+			sb.WriteString(strings.Repeat(" ", n))
+			col += n
+		} else {
+			sb.WriteRune(r)
+			col++
+		}
+	}
+	return sb.String()
+}
+
+func expand_lines(lines []string) []string {
+	r := make([]string, len(lines))
+	for i, line := range lines {
+		r[i] = expand_tabs(line)
 	}
+	return r
+}
+
+func format_line(line string) string {
+	if lineend.Ending(line, linemode()) == "" {
+		return fmt.Sprintf("%s\n\\ %s\n", line, NONEWLINE)
+	}
+	return line
 }
 
 func hasflag(name string) bool {
@@ -565,68 +2040,91 @@ func make_hunk(cl []diff.Change, cstart int, alen int, blen int, context int) (c
 
 	bcount = cl[cend].B + cl[cend].Ins - bstart + context
 	if bstart+bcount > blen {
-		bcount = blen - astart
+		bcount = blen - bstart
 	}
 
 	return
 }
 
+type hunk struct {
+	cl     []diff.Change
+	astart int
+	acount int
+	bstart int
+	bcount int
+}
+
+func make_hunks(cl []diff.Change, alen int, blen int, context int) []hunk {
+	hunks := []hunk{}
+	cstart := 0
+	for cstart < len(cl) {
+		cend, astart, acount, bstart, bcount := make_hunk(cl, cstart, alen, blen, context)
+		hunks = append(hunks, hunk{cl[cstart : cend+1], astart, acount, bstart, bcount})
+		cstart = cend + 1
+	}
+	return hunks
+}
+
 func readfile(path string) ([]string, error) {
-	var fin *os.File
+	var fin io.Reader
 	if path == "-" {
 		fin = os.Stdin
 	} else {
-		f, err := os.Open(path)
+		f, err := openpath(path)
 		if err != nil {
 			return nil, err
 		}
 		defer f.Close()
 		fin = f
 	}
-	var lines []string
-	r := bufio.NewReader(fin)
-	for {
-		line, err := r.ReadString('\n')
+	if !*flag_no_decompress {
+		d, err := decompress.NewReader(fin)
 		if err != nil {
-			if err != io.EOF {
-				return nil, err
-			}
-			if line != "" {
-				lines = append(lines, line)
-			}
-			break
+			return nil, fmt.Errorf("%s: %s", path, err)
 		}
-		lines = append(lines, line)
+		defer d.Close()
+		fin = d
 	}
-	return lines, nil
-}
-
-func readdir(dir string) ([]os.FileInfo, error) {
-	f, err := os.Open(dir)
+	enc, err := charset.Lookup(*flag_encoding)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	return f.Readdir(0)
+	fin, err = charset.NewReader(fin, enc, *flag_ignore_bom)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	mode, err := lineend.ParseMode(*flag_line_endings)
+	if err != nil {
+		return nil, err
+	}
+	return lineend.Split(fin, mode)
+}
+
+// The -line-endings mode, which readfile has checked.
+func linemode() lineend.Mode {
+	mode, _ := lineend.ParseMode(*flag_line_endings)
+	return mode
 }
 
 func reconstructargs() string {
 	args := []string{cmdname()}
-	i := 1
-	for i < len(os.Args) {
-		if (os.Args[i] == "-C" || os.Args[i] == "-U") && !strings.Contains(os.Args[i], "=") {
-			args = append(args, os.Args[i], os.Args[i+1])
-			i += 2
-		} else if strings.HasPrefix(os.Args[i], "-") {
-			args = append(args, os.Args[i])
-			i++
-		} else {
-			i++
-		}
+	for _, o := range options {
+		args = append(args, o.String())
 	}
 	return strings.Join(args, " ")
 }
 
+// Parse args into fs and return the operands.
+func parseargs(fs *flag.FlagSet, aliases []getopt.Alias, args []string) ([]string, error) {
+	p := getopt.Parser{Flags: fs, Aliases: aliases}
+	operands, opts, err := p.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	options = opts
+	return operands, nil
+}
+
 func cmdname() string {
 	name := filepath.Base(os.Args[0])
 	ext := filepath.Ext(name)
@@ -641,11 +2139,19 @@ func xjoinpath(dir string, file string) string {
 	return dir + "/" + file
 }
 
+// Join a path relative to dir in the form of io/fs.
+func xjoinrel(dir string, rel string) string {
+	if rel == "." {
+		return dir
+	}
+	return xjoinpath(dir, rel)
+}
+
 func isdir(path string) (bool, error) {
 	if path == "-" {
 		return false, nil
 	}
-	fi, err := os.Stat(path)
+	fi, err := statpath(path)
 	if err != nil {
 		return false, err
 	}
@@ -656,13 +2162,235 @@ func fmodtime(path string) (time.Time, error) {
 	if path == "-" {
 		return time.Now(), nil
 	}
-	fi, err := os.Stat(path)
+	fi, err := statpath(path)
 	if err != nil {
 		return time.Time{}, err
 	}
 	return fi.ModTime(), nil
 }
 
+// Operands that are read as trees instead of from the file system, keyed by
+// the operand path.  Paths below such an operand are looked up in its tree.
+var trees = map[string]fs.FS{}
+
+// Open the operand path as a tree if it is a manifest or an archive.
+func opentree(path string) error {
+	if _, ok := trees[strings.TrimRight(path, string(os.PathSeparator)+"/")]; path == "-" || ok {
+		return nil
+	}
+	fi, err := os.Stat(path)
+	if err != nil || !fi.Mode().IsRegular() {
+		// Errors are reported when the operand is compared.
+		return nil
+	}
+	fsys, err := openmanifest(path)
+	if err == nil && fsys == nil {
+		fsys, _, err = archive.Open(path)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	if fsys != nil {
+		trees[strings.TrimRight(path, string(os.PathSeparator)+"/")] = fsys
+	}
+	return nil
+}
+
+// Nil when path is not a manifest.
+func openmanifest(path string) (fs.FS, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	head := make([]byte, len(manifest.Header)+1)
+	n, _ := io.ReadFull(f, head)
+	if !manifest.IsManifest(head[:n]) {
+		return nil, nil
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := manifest.Read(f)
+	if err != nil {
+		return nil, err
+	}
+	return manifest.New(entries), nil
+}
+
+// Find the tree containing path and the name of path in it.
+func lookuptree(path string) (fs.FS, string, bool) {
+	for root, fsys := range trees {
+		if path == root {
+			return fsys, ".", true
+		} else if strings.HasPrefix(path, root+"/") {
+			return fsys, path[len(root)+1:], true
+		}
+	}
+	return nil, "", false
+}
+
+func statpath(path string) (fs.FileInfo, error) {
+	if fsys, name, ok := lookuptree(path); ok {
+		return fs.Stat(fsys, name)
+	}
+	return os.Stat(path)
+}
+
+func openpath(path string) (fs.File, error) {
+	if fsys, name, ok := lookuptree(path); ok {
+		return fsys.Open(name)
+	}
+	return os.Open(path)
+}
+
+func readlinkpath(path string) (string, error) {
+	if fsys, name, ok := lookuptree(path); ok {
+		if lfs, ok := fsys.(interface {
+			ReadLink(name string) (string, error)
+		}); ok {
+			return lfs.ReadLink(name)
+		}
+		return "", &fs.PathError{Op: "readlink", Path: path, Err: fs.ErrInvalid}
+	}
+	return os.Readlink(path)
+}
+
+// The file system of a directory operand.  Its errors name files by their
+// path under the operand instead of relative to the file system.
+func dirfs(path string) (fs.FS, error) {
+	if fsys, name, ok := lookuptree(path); ok {
+		sub, err := fs.Sub(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		return operandfs{sub, path}, nil
+	}
+	return operandfs{os.DirFS(path), path}, nil
+}
+
+type operandfs struct {
+	fsys fs.FS
+	dir  string
+}
+
+func (o operandfs) Open(name string) (fs.File, error) {
+	f, err := o.fsys.Open(name)
+	return f, o.fixerr(err)
+}
+
+func (o operandfs) Stat(name string) (fs.FileInfo, error) {
+	fi, err := fs.Stat(o.fsys, name)
+	return fi, o.fixerr(err)
+}
+
+func (o operandfs) ReadDir(name string) ([]fs.DirEntry, error) {
+	dl, err := fs.ReadDir(o.fsys, name)
+	return dl, o.fixerr(err)
+}
+
+func (o operandfs) ReadLink(name string) (string, error) {
+	if lfs, ok := o.fsys.(interface {
+		ReadLink(name string) (string, error)
+	}); ok {
+		target, err := lfs.ReadLink(name)
+		return target, o.fixerr(err)
+	}
+	return "", o.fixerr(&fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid})
+}
+
+func (o operandfs) fixerr(err error) error {
+	if pe, ok := err.(*fs.PathError); ok {
+		return &fs.PathError{Op: pe.Op, Path: xjoinrel(o.dir, pe.Path), Err: pe.Err}
+	}
+	return err
+}
+
+func hascontent(path string) bool {
+	if fsys, name, ok := lookuptree(path); ok {
+		return manifest.HasContent(fsys, name)
+	}
+	return true
+}
+
+// Compare by SHA-256 digest, which a manifest has without content.
+func samedigest(apath string, bpath string) (bool, error) {
+	adigest, err := digestpath(apath)
+	if err != nil {
+		return false, err
+	}
+	bdigest, err := digestpath(bpath)
+	if err != nil {
+		return false, err
+	}
+	return adigest == bdigest, nil
+}
+
+func digestpath(path string) (string, error) {
+	if fsys, name, ok := lookuptree(path); ok {
+		return manifest.Digest(fsys, name)
+	}
+	return manifest.Digest(os.DirFS(filepath.Dir(path)), filepath.Base(path))
+}
+
+func savemanifest(dir string, path string) error {
+	err := opentree(dir)
+	if err != nil {
+		return err
+	}
+	fsys, err := dirfs(dir)
+	if err != nil {
+		return err
+	}
+	entries, err := manifest.Create(fsys, manifest.Options{Content: *flag_manifest_content, NoDereference: *flag_no_dereference})
+	if err != nil {
+		return err
+	}
+	if path == "-" {
+		return manifest.Write(os.Stdout, entries)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = manifest.Write(f, entries)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Standard output.  Everything written to stdout, except for manifests, goes
+// through it.
+var stdout io.Writer = os.Stdout
+
+// Flush the output encoder, if any.
+var closeoutput = func() {}
+
+func exit(code int) {
+	closeoutput()
+	os.Exit(code)
+}
+
+// Encode the output with -output-encoding.  The encoder writes through as it
+// is written to, so the output stays in order with stderr.
+func setoutputencoding() error {
+	if *flag_output_encoding == "" {
+		return nil
+	}
+	enc, err := charset.Lookup(*flag_output_encoding)
+	if err != nil || enc == nil {
+		return err
+	}
+	cw := charset.NewWriter(os.Stdout, enc)
+	stdout = cw
+	closeoutput = func() {
+		cw.Close()
+	}
+	return nil
+}
+
 func print_error(s string) {
 	fmt.Fprintf(os.Stderr, "%s: %s\n", cmdname(), s)
 }
@@ -685,7 +2413,7 @@ func change_to_diff(cl []diff.Change, al []string, bl []string) ([]int, []int) {
 			ad = append(ad, 0)
 			a++
 		}
-		for a < c.A + c.Del {
+		for a < c.A+c.Del {
 			ad = append(ad, -1)
 			a++
 		}
@@ -693,7 +2421,7 @@ func change_to_diff(cl []diff.Change, al []string, bl []string) ([]int, []int) {
 			bd = append(bd, 0)
 			b++
 		}
-		for b < c.B + c.Ins {
+		for b < c.B+c.Ins {
 			bd = append(bd, 1)
 			b++
 		}
@@ -757,11 +2485,11 @@ func change_compact_sub(df []int, lines []string) []int {
 		}
 		start := s
 		end := e
-		for 0 < s && lines[s - 1] == lines[e - 1] {
-			df[s - 1] = df[e - 1]
-			df[e - 1] = 0
+		for 0 < s && lines[s-1] == lines[e-1] {
+			df[s-1] = df[e-1]
+			df[e-1] = 0
 			e--
-			for 0 < s && df[s - 1] != 0 {
+			for 0 < s && df[s-1] != 0 {
 				s--
 			}
 		}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -12,13 +13,14 @@ import (
 )
 
 const CMDNAME = "./diff"
+const SDIFF_CMDNAME = "./sdiff"
 
 func init() {
 	filepath.Walk("diff_test", func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		if !info.IsDir() {
+		if !info.IsDir() && info.Mode()&os.ModeSymlink == 0 {
 			t := time.Date(2015, 1, 2, 3, 4, 5, 67890000, time.UTC)
 			e := os.Chtimes(path, t, t)
 			if e != nil {
@@ -28,6 +30,7 @@ func init() {
 		return nil
 	})
 	exec.Command("go", "build", "diff.go").CombinedOutput()
+	exec.Command("go", "build", "-o", "sdiff", "diff.go").CombinedOutput()
 }
 
 func dotest(t *testing.T, args []string, okfile string, exitcode bool) {
@@ -83,6 +86,45 @@ func dotestin(t *testing.T, args []string, infile string, okfile string, exitcod
 	}
 }
 
+func dosdifftest(t *testing.T, args []string, infile string, okfile string, exitcode bool, outfile string, outokfile string) {
+	os.Remove(outfile)
+	cmd := exec.Command(SDIFF_CMDNAME, append([]string{"-o", outfile}, args...)...)
+	fin, err := os.Open(infile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fin.Close()
+	cmd.Stdin = fin
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			t.Fatal(err)
+		}
+	}
+	cmdexitcode := (err == nil)
+	ok, err := ioutil.ReadFile(okfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, ok) {
+		t.Errorf("error: result mismatch:\nRESULT:\n%s\nEXPECTED:\n%s", string(out), string(ok))
+	} else if cmdexitcode != exitcode {
+		t.Errorf("error: exitcode mismatch:\nRESULT:\n%v\nEXPECTED:\n%v", cmdexitcode, exitcode)
+	}
+	merged, err := ioutil.ReadFile(outfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(outfile)
+	ok, err = ioutil.ReadFile(outokfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(merged, ok) {
+		t.Errorf("error: merged file mismatch:\nRESULT:\n%s\nEXPECTED:\n%s", string(merged), string(ok))
+	}
+}
+
 func Test1(t *testing.T) {
 	dotest(t, []string{"diff_test/test1_a", "diff_test/test1_b"}, "diff_test/test1_ok", true)
 }
@@ -278,3 +320,332 @@ func Test64(t *testing.T) {
 func Test65(t *testing.T) {
 	dotest(t, []string{"-u", "-histogram", "diff_test/test65_a", "diff_test/test65_b"}, "diff_test/test65_ok", false)
 }
+func Test66(t *testing.T) {
+	dotest(t, []string{"-D", "NAME", "diff_test/test66_a", "diff_test/test66_b"}, "diff_test/test66_ok", false)
+}
+func Test67(t *testing.T) {
+	dotest(t, []string{"-D", "NAME", "diff_test/test67_a", "diff_test/test67_b"}, "diff_test/test67_ok", false)
+}
+func Test68(t *testing.T) {
+	dotest(t, []string{"-old-line-format=-%L", "-new-line-format=+%L", "-unchanged-line-format= %L", "diff_test/test68_a", "diff_test/test68_b"}, "diff_test/test68_ok", false)
+}
+func Test69(t *testing.T) {
+	dotest(t, []string{"-old-group-format=%df%(f=l?:,%dl)d%dE\n%<", "-new-group-format=%dea%dF%(F=L?:,%dL)\n%>", "-changed-group-format=%df%(f=l?:,%dl)c%dF%(F=L?:,%dL)\n%<---\n%>", "-unchanged-group-format=", "-old-line-format=< %l\n", "-new-line-format=> %3dn%c':' %l\n", "diff_test/test68_a", "diff_test/test68_b"}, "diff_test/test69_ok", false)
+}
+func Test70(t *testing.T) {
+	dotest(t, []string{"-n", "diff_test/test70_a", "diff_test/test70_b"}, "diff_test/test70_ok", false)
+}
+func Test71(t *testing.T) {
+	dotest(t, []string{"-e", "-verify", "diff_test/test71_a", "diff_test/test71_b"}, "diff_test/test71_ok", false)
+}
+func Test72(t *testing.T) {
+	dotest(t, []string{"-f", "-verify", "diff_test/test71_a", "diff_test/test71_b"}, "diff_test/test72_ok", false)
+}
+func Test73(t *testing.T) {
+	dotest(t, []string{"-e", "-verify", "diff_test/test72_a", "diff_test/test72_b"}, "diff_test/test73_ok", false)
+}
+func Test74(t *testing.T) {
+	dotestin(t, []string{"-interactive=patch", "-U", "1", "diff_test/test74_a", "diff_test/test74_b"}, "diff_test/test74_in", "diff_test/test74_ok", false)
+}
+func Test75(t *testing.T) {
+	dotestin(t, []string{"-interactive=file", "diff_test/test74_a", "diff_test/test74_b"}, "diff_test/test75_in", "diff_test/test75_ok", false)
+}
+func Test76(t *testing.T) {
+	dotest(t, []string{"-y", "diff_test/test76_a", "diff_test/test76_b"}, "diff_test/test76_ok", false)
+}
+func Test77(t *testing.T) {
+	dotest(t, []string{"-y", "-W", "40", "-left-column", "diff_test/test76_a", "diff_test/test76_b"}, "diff_test/test77_ok", false)
+}
+func Test78(t *testing.T) {
+	dosdifftest(t, []string{"-s", "-w", "60", "diff_test/test76_a", "diff_test/test76_b"}, "diff_test/test78_in", "diff_test/test78_ok", false, "diff_test/test78_out", "diff_test/test78_merged")
+}
+func Test79(t *testing.T) {
+	dotest(t, []string{"-merge", "diff_test/test79_a", "diff_test/test79_o", "diff_test/test79_b"}, "diff_test/test79_ok", false)
+}
+func Test80(t *testing.T) {
+	dotest(t, []string{"-merge", "-conflict-style=diff3", "diff_test/test79_a", "diff_test/test79_o", "diff_test/test79_b"}, "diff_test/test80_ok", false)
+}
+func Test81(t *testing.T) {
+	dotest(t, []string{"-merge", "-conflict-style=zdiff3", "-patience", "diff_test/test79_a", "diff_test/test79_o", "diff_test/test79_b"}, "diff_test/test81_ok", false)
+}
+func Test82(t *testing.T) {
+	dotest(t, []string{"-merge", "diff_test/test79_a", "diff_test/test79_o", "diff_test/test79_o"}, "diff_test/test82_ok", true)
+}
+func Test83(t *testing.T) {
+	dotest(t, []string{"-conflicts", "diff_test/test83_a", "diff_test/test83_b", "diff_test/test83_c"}, "diff_test/test83_ok", false)
+}
+func Test84(t *testing.T) {
+	dotest(t, []string{"-conflicts", "diff_test/test83_c"}, "diff_test/test84_ok", true)
+}
+func Test85(t *testing.T) {
+	dotest(t, []string{"-conflicts", "diff_test/test84_a"}, "diff_test/test85_ok", false)
+}
+func Test86(t *testing.T) {
+	dotest(t, []string{"-r", "-j=4", "diff_test/test49_a", "diff_test/test49_b"}, "diff_test/test86_ok", false)
+}
+func Test87(t *testing.T) {
+	dotest(t, []string{"-r", "-ignore-file-name-case", "diff_test/test87_a", "diff_test/test87_b"}, "diff_test/test87_ok", false)
+}
+func Test88(t *testing.T) {
+	dotest(t, []string{"-r", "diff_test/test88_a", "diff_test/test88_b"}, "diff_test/test88_ok", false)
+}
+func Test89(t *testing.T) {
+	dotest(t, []string{"-r", "-no-dereference", "diff_test/test88_a", "diff_test/test88_b"}, "diff_test/test89_ok", false)
+}
+func setuptest90(t *testing.T) {
+	mtime := time.Date(2015, 1, 2, 3, 4, 5, 67890000, time.UTC)
+	for _, e := range []struct {
+		path  string
+		mode  os.FileMode
+		delta time.Duration
+	}{
+		{"diff_test/test90_a/f", 0600, 0},
+		{"diff_test/test90_b/f", 0640, 0},
+		{"diff_test/test90_a/g", 0644, 0},
+		{"diff_test/test90_b/g", 0644, 500 * time.Millisecond},
+		{"diff_test/test90_a/h", 0644, 0},
+		{"diff_test/test90_b/h", 0644, 2 * time.Second},
+	} {
+		if err := os.Chmod(e.path, e.mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(e.path, mtime.Add(e.delta), mtime.Add(e.delta)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+func Test90(t *testing.T) {
+	setuptest90(t)
+	dotest(t, []string{"-metadata=mode,mtime", "-mtime-tolerance=1s", "diff_test/test90_a", "diff_test/test90_b"}, "diff_test/test90_ok", false)
+}
+func Test91(t *testing.T) {
+	setuptest90(t)
+	dotest(t, []string{"-metadata=mode,mtime", "-metadata-format=json", "diff_test/test90_a", "diff_test/test90_b"}, "diff_test/test91_ok", false)
+}
+func Test92(t *testing.T) {
+	dotest(t, []string{"-r", "diff_test/test92_m", "diff_test/test92_b"}, "diff_test/test92_ok", false)
+}
+func Test93(t *testing.T) {
+	dotest(t, []string{"-r", "-u", "diff_test/test93_m", "diff_test/test92_b"}, "diff_test/test93_ok", false)
+}
+func Test94(t *testing.T) {
+	dotest(t, []string{"-r", "-u", "diff_test/test94_a.tar", "diff_test/test94_b.tar.gz"}, "diff_test/test94_ok", false)
+}
+func Test95(t *testing.T) {
+	dotest(t, []string{"-r", "-no-dereference", "diff_test/test94_a.tar", "diff_test/test94_b.tar.gz"}, "diff_test/test95_ok", false)
+}
+func Test96(t *testing.T) {
+	dotest(t, []string{"diff_test/test94_a.tar", "diff_test/test94_c.zip"}, "diff_test/test96_ok", false)
+}
+func Test97(t *testing.T) {
+	dotest(t, []string{"-u", "diff_test/test97_a.gz", "diff_test/test97_b.bz2"}, "diff_test/test97_ok", false)
+}
+func Test98(t *testing.T) {
+	dotest(t, []string{"diff_test/test97_a.gz", "diff_test/test97_c.zst"}, "diff_test/test98_ok", false)
+}
+func Test99(t *testing.T) {
+	dotest(t, []string{"diff_test/test99_a", "diff_test/test97_a.gz"}, "diff_test/test99_ok", true)
+}
+func Test100(t *testing.T) {
+	dotest(t, []string{"-no-decompress", "diff_test/test99_a", "diff_test/test97_a.gz"}, "diff_test/test100_ok", false)
+}
+func Test101(t *testing.T) {
+	dotest(t, []string{"diff_test/test101_a", "diff_test/test101_b"}, "diff_test/test101_ok", false)
+}
+func Test102(t *testing.T) {
+	dotest(t, []string{"-ignore-bom", "diff_test/test101_a", "diff_test/test101_b"}, "diff_test/test102_ok", false)
+}
+func Test103(t *testing.T) {
+	dotest(t, []string{"-ignore-bom", "diff_test/test101_a", "diff_test/test101_c"}, "diff_test/test103_ok", true)
+}
+func Test104(t *testing.T) {
+	dotest(t, []string{"-encoding=shift_jis", "diff_test/test101_d", "diff_test/test101_g"}, "diff_test/test104_ok", false)
+}
+func Test105(t *testing.T) {
+	dotest(t, []string{"-encoding=euc-jp", "-output-encoding=shift_jis", "diff_test/test101_f", "diff_test/test101_e"}, "diff_test/test105_ok", false)
+}
+func Test106(t *testing.T) {
+	dotest(t, []string{"diff_test/test106_a", "diff_test/test106_b"}, "diff_test/test106_ok", false)
+}
+func Test107(t *testing.T) {
+	dotest(t, []string{"-ignore-line-endings", "diff_test/test106_a", "diff_test/test106_b"}, "diff_test/test107_ok", true)
+}
+func Test108(t *testing.T) {
+	dotest(t, []string{"-line-endings=any", "diff_test/test106_c", "diff_test/test106_b"}, "diff_test/test108_ok", false)
+}
+func Test109(t *testing.T) {
+	dotest(t, []string{"-line-endings=any", "-ignore-line-endings", "-u", "diff_test/test106_c", "diff_test/test106_a"}, "diff_test/test109_ok", false)
+}
+func Test110(t *testing.T) {
+	dotest(t, []string{"-t", "diff_test/test110_a", "diff_test/test110_b"}, "diff_test/test110_ok", false)
+}
+func Test111(t *testing.T) {
+	dotest(t, []string{"-T", "diff_test/test110_a", "diff_test/test110_b"}, "diff_test/test111_ok", false)
+}
+func Test112(t *testing.T) {
+	dotest(t, []string{"-tabsize=4", "-t", "diff_test/test110_a", "diff_test/test110_b"}, "diff_test/test112_ok", false)
+}
+func Test113(t *testing.T) {
+	dotest(t, []string{"-u", "-T", "diff_test/test110_a", "diff_test/test110_b"}, "diff_test/test113_ok", false)
+}
+func Test114(t *testing.T) {
+	dotest(t, []string{"-c", "-T", "diff_test/test110_a", "diff_test/test110_b"}, "diff_test/test114_ok", false)
+}
+func Test115(t *testing.T) {
+	dotest(t, []string{"-e", "-t", "diff_test/test110_a", "diff_test/test110_b"}, "diff_test/test115_ok", false)
+}
+func Test116(t *testing.T) {
+	dotest(t, []string{"-y", "-W", "40", "-tabsize=4", "diff_test/test110_a", "diff_test/test110_b"}, "diff_test/test116_ok", false)
+}
+func Test117(t *testing.T) {
+	dotest(t, []string{"-y", "-W", "40", "-t", "diff_test/test110_a", "diff_test/test110_b"}, "diff_test/test117_ok", false)
+}
+func Test118(t *testing.T) {
+	dotest(t, []string{"-u", "-label", "X", "-label", "Y", "diff_test/test9_a", "diff_test/test9_b"}, "diff_test/test118_ok", false)
+}
+func Test119(t *testing.T) {
+	dotest(t, []string{"-c", "-label", "X", "diff_test/test9_a", "diff_test/test9_b"}, "diff_test/test119_ok", false)
+}
+func Test120(t *testing.T) {
+	dotest(t, []string{"-u", "-time-style=none", "diff_test/test9_a", "diff_test/test9_b"}, "diff_test/test120_ok", false)
+}
+func Test121(t *testing.T) {
+	dotest(t, []string{"-c", "-time-style=iso", "diff_test/test9_a", "diff_test/test9_b"}, "diff_test/test121_ok", false)
+}
+func Test122(t *testing.T) {
+	dotest(t, []string{"-u", "-time-style=rfc3339", "diff_test/test9_a", "diff_test/test9_b"}, "diff_test/test122_ok", false)
+}
+func Test123(t *testing.T) {
+	dotest(t, []string{"-u", "-time-style=+2006/01/02", "diff_test/test9_a", "diff_test/test9_b"}, "diff_test/test123_ok", false)
+}
+func Test124(t *testing.T) {
+	dotest(t, []string{"-ru", "diff_test/test49_a", "diff_test/test49_b"}, "diff_test/test124_ok", false)
+}
+func Test125(t *testing.T) {
+	dotest(t, []string{"diff_test/test9_a", "diff_test/test9_b", "--context=1", "--label", "X", "--lab=Y"}, "diff_test/test125_ok", false)
+}
+func Test126(t *testing.T) {
+	dotest(t, []string{"--from-file=diff_test/test9_a", "diff_test/test9_b", "diff_test/test110_a"}, "diff_test/test126_ok", false)
+}
+func Test127(t *testing.T) {
+	dotest(t, []string{"-u", "--to-file", "diff_test/test9_a", "diff_test/test9_b", "diff_test/missing", "diff_test/test9_a"}, "diff_test/test127_ok", false)
+}
+func Test128(t *testing.T) {
+	dotest(t, []string{"--from-file=diff_test/test9_a", "--to-file=diff_test/test9_b"}, "diff_test/test128_ok", false)
+}
+func Test129(t *testing.T) {
+	dotest(t, []string{"-abs-tolerance=1e-6", "diff_test/test129_a", "diff_test/test129_b"}, "diff_test/test129_ok", false)
+}
+func Test130(t *testing.T) {
+	dotest(t, []string{"-rel-tolerance=1e-6", "-y", "-W", "60", "diff_test/test129_a", "diff_test/test129_b"}, "diff_test/test130_ok", false)
+}
+func Test131(t *testing.T) {
+	dotest(t, []string{"-abs-tolerance=1e-3", "-u", "diff_test/test129_a", "diff_test/test129_b"}, "diff_test/test131_ok", true)
+}
+func Test132(t *testing.T) {
+	dotest(t, []string{"-normalize=timestamp", "-normalize", "uuid", "-normalize=address", "-normalize=tmppath", "-u", "diff_test/test132_a", "diff_test/test132_b"}, "diff_test/test132_ok", false)
+}
+func Test133(t *testing.T) {
+	dotest(t, []string{"-normalize=s/[0-9a-f]{8}(-[0-9a-f]{4}){3}-[0-9a-f]{12}/ID/", "-normalize=s/status [0-9]+/status/", "diff_test/test132_a", "diff_test/test132_b"}, "diff_test/test133_ok", false)
+}
+func Test134(t *testing.T) {
+	dotest(t, []string{"-region-begin=^BEGIN", "-region-end=^END", "-u", "diff_test/test134_a", "diff_test/test134_b"}, "diff_test/test134_ok", false)
+}
+func Test135(t *testing.T) {
+	dotest(t, []string{"-lines=3,5", "-y", "-W", "50", "diff_test/test134_a", "diff_test/test134_b"}, "diff_test/test135_ok", false)
+}
+func Test136(t *testing.T) {
+	dotest(t, []string{"-lines=9,13:10,14", "-line-format=%dn:%L", "diff_test/test134_a", "diff_test/test134_b"}, "diff_test/test136_ok", false)
+}
+func Test137(t *testing.T) {
+	dotest(t, []string{"-region-begin=^BEGIN", "-region-end=^END", "-e", "-verify", "diff_test/test134_a", "diff_test/test134_b"}, "diff_test/test137_ok", false)
+}
+func Test138(t *testing.T) {
+	dotest(t, []string{"-lines=8,12:9,13", "-u", "diff_test/test134_a", "diff_test/test134_b"}, "diff_test/test138_ok", false)
+}
+func TestSamefile(t *testing.T) {
+	dir := t.TempDir()
+	big := make([]byte, 100*1024)
+	for i := range big {
+		big[i] = byte('a' + i%26)
+	}
+	bigdiff := append([]byte{}, big...)
+	bigdiff[len(bigdiff)-1] = '!'
+	files := map[string][]byte{
+		"a":       []byte("abcd\n"),
+		"b":       []byte("abcd\n"),
+		"c":       []byte("abce\n"),
+		"d":       []byte("abcde\n"),
+		"big":     big,
+		"bigsame": big,
+		"bigdiff": bigdiff,
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Link(filepath.Join(dir, "a"), filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"a", "d", false},
+		{"a", "a", true},
+		{"a", "link", true},
+		{"a", "b", true},
+		{"a", "c", false},
+		{"big", "bigsame", true},
+		{"big", "bigdiff", false},
+	}
+	for _, tt := range tests {
+		same, err := samefile(filepath.Join(dir, tt.a), filepath.Join(dir, tt.b))
+		if err != nil {
+			t.Errorf("%s %s: %v", tt.a, tt.b, err)
+		} else if same != tt.same {
+			t.Errorf("%s %s: %v, expected %v", tt.a, tt.b, same, tt.same)
+		}
+	}
+	if _, err := samefile(filepath.Join(dir, "a"), filepath.Join(dir, "none")); err == nil {
+		t.Error("error expected")
+	}
+}
+func TestDirfs(t *testing.T) {
+	dir := t.TempDir()
+	fsys, err := dirfs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "open " + dir + "/sub: no such file or directory"
+	if _, err := fsys.Open("sub"); err == nil || err.Error() != expected {
+		t.Errorf("Open: %v, expected %q", err, expected)
+	}
+	expected = "stat " + dir + "/sub: no such file or directory"
+	if _, err := fs.Stat(fsys, "sub"); err == nil || err.Error() != expected {
+		t.Errorf("Stat: %v, expected %q", err, expected)
+	}
+	expected = "open " + dir + "/sub: no such file or directory"
+	if _, err := fs.ReadDir(fsys, "sub"); err == nil || err.Error() != expected {
+		t.Errorf("ReadDir: %v, expected %q", err, expected)
+	}
+}
+func Test140(t *testing.T) {
+	dotest(t, []string{"-u", "diff_test/test106_a", "diff_test/test106_b"}, "diff_test/test140_ok", false)
+}
+func Test141(t *testing.T) {
+	dotest(t, []string{"-b", "diff_test/test141_a", "diff_test/test141_b"}, "diff_test/test141_ok", false)
+}
+func Test142(t *testing.T) {
+	dotest(t, []string{"-b", "-ignore-line-endings", "diff_test/test141_a", "diff_test/test141_b"}, "diff_test/test142_ok", true)
+}
+func Test143(t *testing.T) {
+	dotest(t, []string{"-normalize=s/[0-9]+$/N/", "diff_test/test143_a", "diff_test/test143_b"}, "diff_test/test143_ok", true)
+}
+func Test144(t *testing.T) {
+	dosdifftest(t, []string{"-lines", "9,13:10,14", "-w", "50", "diff_test/test134_a", "diff_test/test134_b"}, "diff_test/test144_in", "diff_test/test144_ok", false, "diff_test/test144_out", "diff_test/test144_merged")
+}
+func Test145(t *testing.T) {
+	dosdifftest(t, []string{"-s", "-w", "60", "diff_test/test76_a", "diff_test/test76_b"}, "diff_test/test145_in", "diff_test/test145_ok", false, "diff_test/test145_out", "diff_test/test145_merged")
+}
@@ -0,0 +1,311 @@
+// Archives as file systems
+//
+// Tar, gzip compressed tar and zip archives are opened as an fs.FS of their
+// members.  Archives are detected by content, not by file name:
+//
+//   tar     "ustar" at offset 257
+//   tar.gz  gzip magic 1f 8b, and a tar archive inside
+//   zip     "PK\x03\x04", or "PK\x05\x06" for an empty archive
+//
+// Tar members are read into memory.  Directories missing from the archive
+// are added, symbolic links are followed by Open and Stat and can be read
+// with ReadLink, and hard links are copies of their target.
+
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Open opens the archive at path.  It returns false when path is not an
+// archive.
+func Open(path string) (fs.FS, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, false, err
+	}
+	head = head[:n]
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, false, err
+	}
+	switch {
+	case istar(head):
+		fsys, err := readtar(f)
+		return fsys, true, err
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")) || bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		// The reader is kept open for the life of the file system.
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, true, err
+		}
+		return zr, true, nil
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, false, nil
+		}
+		inner := make([]byte, 512)
+		n, _ := io.ReadFull(zr, inner)
+		if !istar(inner[:n]) {
+			return nil, false, nil
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, true, err
+		}
+		if err := zr.Reset(f); err != nil {
+			return nil, true, err
+		}
+		fsys, err := readtar(zr)
+		return fsys, true, err
+	}
+	return nil, false, nil
+}
+
+func istar(head []byte) bool {
+	return len(head) >= 262 && string(head[257:262]) == "ustar"
+}
+
+func readtar(r io.Reader) (*memfs, error) {
+	m := newmemfs()
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		name := clean(h.Name)
+		if name == "" {
+			continue
+		}
+		f := &memfile{name: name, mode: h.FileInfo().Mode(), modtime: h.ModTime}
+		switch h.Typeflag {
+		case tar.TypeReg:
+			f.data, err = io.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+		case tar.TypeLink:
+			target, ok := m.files[clean(h.Linkname)]
+			if !ok {
+				return nil, errors.New("tar: hard link to missing file " + h.Linkname)
+			}
+			f.mode = target.mode
+			f.data = target.data
+		case tar.TypeSymlink:
+			f.target = h.Linkname
+		}
+		m.add(f)
+	}
+	m.sort()
+	return m, nil
+}
+
+// Member names are relative to the root, with or without a leading "./" or
+// "/".  Names with ".." are dropped.
+func clean(name string) string {
+	for _, elem := range strings.Split(name, "/") {
+		if elem == ".." {
+			return ""
+		}
+	}
+	return path.Clean("/" + name)[1:]
+}
+
+type memfs struct {
+	files    map[string]*memfile
+	children map[string][]string
+}
+
+type memfile struct {
+	name    string
+	mode    fs.FileMode
+	modtime time.Time
+	data    []byte
+	// Target of a symbolic link.
+	target string
+}
+
+func newmemfs() *memfs {
+	m := &memfs{files: map[string]*memfile{}, children: map[string][]string{}}
+	m.files["."] = &memfile{name: ".", mode: fs.ModeDir | 0755}
+	return m
+}
+
+func (m *memfs) add(f *memfile) {
+	if _, ok := m.files[f.name]; ok {
+		m.files[f.name] = f
+		return
+	}
+	dir := path.Dir(f.name)
+	if _, ok := m.files[dir]; !ok {
+		m.add(&memfile{name: dir, mode: fs.ModeDir | 0755, modtime: f.modtime})
+	}
+	m.files[f.name] = f
+	m.children[dir] = append(m.children[dir], path.Base(f.name))
+}
+
+func (m *memfs) sort() {
+	for _, names := range m.children {
+		sort.Strings(names)
+	}
+}
+
+// Find name, following symbolic links in the path, and in the last element
+// unless nofollow is set.
+func (m *memfs) lookup(op string, name string, nofollow bool) (*memfile, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	elems := split(name)
+	cur := "."
+	hops := 0
+	for i := 0; i < len(elems); i++ {
+		next := path.Join(cur, elems[i])
+		f, ok := m.files[next]
+		if !ok {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		if f.mode&fs.ModeSymlink == 0 || (nofollow && i == len(elems)-1) {
+			cur = next
+			continue
+		}
+		hops++
+		if hops > 40 {
+			return nil, &fs.PathError{Op: op, Path: name, Err: errors.New("too many levels of symbolic links")}
+		}
+		// Start over with the target, which is relative to the
+		// directory of the link or to the root.
+		target := f.target
+		if !strings.HasPrefix(target, "/") {
+			target = path.Join(cur, target)
+		}
+		elems = append(split(path.Clean("/" + target)[1:]), elems[i+1:]...)
+		cur = "."
+		i = -1
+	}
+	return m.files[cur], nil
+}
+
+func split(name string) []string {
+	if name == "" || name == "." {
+		return nil
+	}
+	return strings.Split(name, "/")
+}
+
+func (m *memfs) Open(name string) (fs.File, error) {
+	f, err := m.lookup("open", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return &openfile{m: m, f: f, info: info{f, path.Base(name)}, r: bytes.NewReader(f.data)}, nil
+}
+
+func (m *memfs) Stat(name string) (fs.FileInfo, error) {
+	f, err := m.lookup("stat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return info{f, path.Base(name)}, nil
+}
+
+func (m *memfs) ReadDir(name string) ([]fs.DirEntry, error) {
+	f, err := m.lookup("readdir", name, false)
+	if err != nil {
+		return nil, err
+	}
+	if !f.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	dl := []fs.DirEntry{}
+	for _, child := range m.children[f.name] {
+		c := m.files[path.Join(f.name, child)]
+		dl = append(dl, fs.FileInfoToDirEntry(info{c, child}))
+	}
+	return dl, nil
+}
+
+// ReadLink returns the target of the symbolic link name.
+func (m *memfs) ReadLink(name string) (string, error) {
+	f, err := m.lookup("readlink", name, true)
+	if err != nil {
+		return "", err
+	}
+	if f.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return f.target, nil
+}
+
+// The name is the one looked up, which differs from the name of the file
+// for a symbolic link.
+type info struct {
+	f    *memfile
+	name string
+}
+
+func (fi info) Name() string       { return fi.name }
+func (fi info) Size() int64        { return int64(len(fi.f.data)) }
+func (fi info) Mode() fs.FileMode  { return fi.f.mode }
+func (fi info) ModTime() time.Time { return fi.f.modtime }
+func (fi info) IsDir() bool        { return fi.f.mode.IsDir() }
+func (fi info) Sys() interface{}   { return nil }
+
+type openfile struct {
+	m    *memfs
+	f    *memfile
+	info info
+	r    *bytes.Reader
+	// Offset of ReadDir.
+	off int
+}
+
+func (o *openfile) Stat() (fs.FileInfo, error) {
+	return o.info, nil
+}
+
+func (o *openfile) Read(b []byte) (int, error) {
+	if o.f.mode.IsDir() {
+		return 0, &fs.PathError{Op: "read", Path: o.f.name, Err: errors.New("is a directory")}
+	}
+	return o.r.Read(b)
+}
+
+func (o *openfile) ReadDir(n int) ([]fs.DirEntry, error) {
+	dl, err := o.m.ReadDir(o.f.name)
+	if err != nil {
+		return nil, err
+	}
+	dl = dl[o.off:]
+	if n > 0 && len(dl) > n {
+		dl = dl[:n]
+	}
+	o.off += len(dl)
+	if n > 0 && len(dl) == 0 {
+		return nil, io.EOF
+	}
+	return dl, nil
+}
+
+func (o *openfile) Close() error {
+	return nil
+}
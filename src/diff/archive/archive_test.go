@@ -0,0 +1,122 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func writetar(t *testing.T, gz bool) string {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	mtime := time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, h := range []tar.Header{
+		{Name: "./d/a.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 2, ModTime: mtime},
+		{Name: "./d/hard", Typeflag: tar.TypeLink, Linkname: "d/a.txt", ModTime: mtime},
+		{Name: "./link", Typeflag: tar.TypeSymlink, Linkname: "d/a.txt", ModTime: mtime},
+		{Name: "./dirlink", Typeflag: tar.TypeSymlink, Linkname: "d", ModTime: mtime},
+		{Name: "../outside", Typeflag: tar.TypeReg, Mode: 0644, ModTime: mtime},
+	} {
+		h := h
+		if err := tw.WriteHeader(&h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Size != 0 {
+			tw.Write([]byte("a\n"))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	name := "a.tar"
+	if gz {
+		var zbuf bytes.Buffer
+		zw := gzip.NewWriter(&zbuf)
+		zw.Write(data)
+		zw.Close()
+		data = zbuf.Bytes()
+		name = "a.tar.gz"
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTar(t *testing.T) {
+	for _, gz := range []bool{false, true} {
+		fsys, ok, err := Open(writetar(t, gz))
+		if err != nil || !ok {
+			t.Fatalf("gz=%v: %v %v", gz, ok, err)
+		}
+		if err := fstest.TestFS(fsys, "d/a.txt", "d/hard", "link"); err != nil {
+			t.Error(err)
+		}
+		for _, name := range []string{"d/hard", "link", "dirlink/a.txt"} {
+			data, err := fs.ReadFile(fsys, name)
+			if err != nil || string(data) != "a\n" {
+				t.Errorf("%s: %q %v", name, data, err)
+			}
+		}
+		if _, err := fs.Stat(fsys, "outside"); err == nil {
+			t.Error("member outside of the root must be dropped")
+		}
+		target, err := fsys.(*memfs).ReadLink("link")
+		if err != nil || target != "d/a.txt" {
+			t.Errorf("ReadLink: %q %v", target, err)
+		}
+		dl, err := fs.ReadDir(fsys, ".")
+		if err != nil || len(dl) != 3 || dl[2].Type() != fs.ModeSymlink {
+			t.Errorf("ReadDir: %v %v", dl, err)
+		}
+	}
+}
+
+func TestZip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("d/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("a\n"))
+	zw.Close()
+	path := filepath.Join(t.TempDir(), "a.zip")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fsys, ok, err := Open(path)
+	if err != nil || !ok {
+		t.Fatalf("%v %v", ok, err)
+	}
+	data, err := fs.ReadFile(fsys, "d/a.txt")
+	if err != nil || string(data) != "a\n" {
+		t.Errorf("%q %v", data, err)
+	}
+}
+
+func TestNotArchive(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{
+		"text":  []byte("a\n"),
+		"empty": {},
+		"gz":    {0x1f, 0x8b, 0},
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok, err := Open(path); ok || err != nil {
+			t.Errorf("%s: %v %v", name, ok, err)
+		}
+	}
+}
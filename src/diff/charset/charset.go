@@ -0,0 +1,90 @@
+// Character encodings of input and output
+//
+// Input is decoded to UTF-8 before it is split into lines.  A byte order
+// mark selects the encoding regardless of the one given:
+//
+//   ef bb bf  UTF-8
+//   ff fe     UTF-16LE
+//   fe ff     UTF-16BE
+//
+// The byte order mark is kept as U+FEFF at the beginning of the decoded text,
+// unless it is stripped.  UTF-8 input is not validated.
+
+package charset
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+	"io"
+)
+
+var (
+	bom_utf8    = []byte{0xef, 0xbb, 0xbf}
+	bom_utf16le = []byte{0xff, 0xfe}
+	bom_utf16be = []byte{0xfe, 0xff}
+)
+
+// Lookup returns the encoding of name, such as "shift_jis", "euc-jp" or
+// "utf-16le".  It is nil for UTF-8 and for an empty name.
+func Lookup(name string) (encoding.Encoding, error) {
+	if name == "" {
+		return nil, nil
+	}
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown encoding '%s'", name)
+	}
+	if enc == unicode.UTF8 {
+		return nil, nil
+	}
+	return enc, nil
+}
+
+// NewReader returns a reader of r decoded from enc, or from the encoding of
+// its byte order mark.  A nil enc is UTF-8.
+func NewReader(r io.Reader, enc encoding.Encoding, stripbom bool) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(3)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(head, bom_utf8):
+		enc = nil
+	case bytes.HasPrefix(head, bom_utf16le):
+		enc = unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	case bytes.HasPrefix(head, bom_utf16be):
+		enc = unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)
+	}
+	if enc == nil && !stripbom {
+		return br, nil
+	}
+	var out io.Reader = br
+	if enc != nil {
+		out = transform.NewReader(br, enc.NewDecoder())
+	}
+	if stripbom {
+		bo := bufio.NewReader(out)
+		head, err := bo.Peek(3)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if bytes.HasPrefix(head, bom_utf8) {
+			bo.Discard(len(bom_utf8))
+		}
+		out = bo
+	}
+	return out, nil
+}
+
+// NewWriter returns a writer that encodes UTF-8 text to enc and writes it to
+// w.  Characters that enc cannot represent are replaced.  It must be closed
+// to flush the output.
+func NewWriter(w io.Writer, enc encoding.Encoding) io.WriteCloser {
+	return transform.NewWriter(w, encoding.ReplaceUnsupported(enc.NewEncoder()))
+}
@@ -0,0 +1,64 @@
+package charset
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func decode(t *testing.T, data string, name string, stripbom bool) string {
+	enc, err := Lookup(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(bytes.NewBufferString(data), enc, stripbom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestNewReader(t *testing.T) {
+	for _, c := range []struct {
+		data     string
+		name     string
+		stripbom bool
+		expected string
+	}{
+		{"a\n", "", false, "a\n"},
+		{"\xef\xbb\xbfa\n", "", false, "\ufeffa\n"},
+		{"\xef\xbb\xbfa\n", "", true, "a\n"},
+		{"\xff\xfea\x00\n\x00", "", false, "\ufeffa\n"},
+		{"\xfe\xff\x00a\x00\n", "", true, "a\n"},
+		{"\xef\xbb\xbfa\n", "shift_jis", false, "\ufeffa\n"},
+		{"\x82\xa0\n", "shift_jis", false, "あ\n"},
+		{"\xa4\xa2\n", "euc-jp", false, "あ\n"},
+		{"a\x00\n\x00", "utf-16le", false, "a\n"},
+		{"\xff\n", "", false, "\xff\n"},
+	} {
+		if out := decode(t, c.data, c.name, c.stripbom); out != c.expected {
+			t.Errorf("%q %s: RESULT %q EXPECTED %q", c.data, c.name, out, c.expected)
+		}
+	}
+}
+
+func TestNewWriter(t *testing.T) {
+	enc, err := Lookup("shift_jis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf, enc)
+	io.WriteString(w, "あ\né\n")
+	w.Close()
+	if buf.String() != "\x82\xa0\n\x1a\n" {
+		t.Errorf("%q", buf.String())
+	}
+	if _, err := Lookup("no-such-encoding"); err == nil {
+		t.Error("error expected")
+	}
+}
@@ -0,0 +1,165 @@
+// Conflict marker parser
+//
+// Parses text with merge style or diff3 style conflict markers, as written by
+// diff/merge, diff3 -m and git:
+//
+//   <<<<<<< ours
+//   lines of ours
+//   ||||||| base          (diff3 style only)
+//   lines of base
+//   =======
+//   lines of theirs
+//   >>>>>>> theirs
+//
+// A marker is seven marker characters at the beginning of a line, followed
+// by a space or the end of the line.  Outside of a conflict, only <<<<<<< is
+// a marker.
+
+package conflict
+
+import (
+	"diff/merge"
+	"fmt"
+	"strings"
+)
+
+type Region struct {
+	// False for text outside of conflict markers, which is kept in Ours.
+	Conflict    bool
+	Ours        []string
+	Base        []string
+	Theirs      []string
+	HasBase     bool
+	OursLabel   string
+	BaseLabel   string
+	TheirsLabel string
+	// Lines [Start, Start+Count) of the parsed text including the marker
+	// lines, zero based.
+	Start int
+	Count int
+}
+
+type Strategy int
+
+const (
+	Ours Strategy = iota
+	Theirs
+	Union
+)
+
+const (
+	state_text = iota
+	state_ours
+	state_base
+	state_theirs
+)
+
+// Parse splits lines into text and conflict regions.
+func Parse(lines []string) ([]Region, error) {
+	regions := []Region{}
+	state := state_text
+	r := Region{}
+	for i, line := range lines {
+		marker, label := parse_marker(line)
+		switch state {
+		case state_text:
+			if marker == merge.MarkerOurs {
+				if len(r.Ours) != 0 {
+					r.Count = i - r.Start
+					regions = append(regions, r)
+				}
+				r = Region{Conflict: true, OursLabel: label, Start: i}
+				state = state_ours
+			} else {
+				r.Ours = append(r.Ours, line)
+			}
+		case state_ours:
+			if marker == merge.MarkerBase {
+				r.HasBase = true
+				r.BaseLabel = label
+				state = state_base
+			} else if marker == merge.MarkerSep {
+				state = state_theirs
+			} else if marker != "" {
+				return nil, fmt.Errorf("line %d: unexpected %s marker", i+1, marker)
+			} else {
+				r.Ours = append(r.Ours, line)
+			}
+		case state_base:
+			if marker == merge.MarkerSep {
+				state = state_theirs
+			} else if marker != "" {
+				return nil, fmt.Errorf("line %d: unexpected %s marker", i+1, marker)
+			} else {
+				r.Base = append(r.Base, line)
+			}
+		case state_theirs:
+			if marker == merge.MarkerTheirs {
+				r.TheirsLabel = label
+				r.Count = i + 1 - r.Start
+				regions = append(regions, r)
+				r = Region{Start: i + 1}
+				state = state_text
+			} else if marker != "" {
+				return nil, fmt.Errorf("line %d: unexpected %s marker", i+1, marker)
+			} else {
+				r.Theirs = append(r.Theirs, line)
+			}
+		}
+	}
+	if state != state_text {
+		return nil, fmt.Errorf("line %d: unterminated conflict", r.Start+1)
+	}
+	if len(r.Ours) != 0 {
+		r.Count = len(lines) - r.Start
+		regions = append(regions, r)
+	}
+	return regions, nil
+}
+
+func parse_marker(line string) (string, string) {
+	for _, marker := range []string{merge.MarkerOurs, merge.MarkerBase, merge.MarkerSep, merge.MarkerTheirs} {
+		if !strings.HasPrefix(line, marker) {
+			continue
+		}
+		rest := strings.TrimSuffix(strings.TrimSuffix(line[len(marker):], "\n"), "\r")
+		if rest == "" {
+			return marker, ""
+		} else if rest[0] == ' ' && marker != merge.MarkerSep {
+			return marker, rest[1:]
+		}
+	}
+	return "", ""
+}
+
+// Conflicts returns the conflict regions.
+func Conflicts(regions []Region) []Region {
+	cl := []Region{}
+	for _, r := range regions {
+		if r.Conflict {
+			cl = append(cl, r)
+		}
+	}
+	return cl
+}
+
+// Resolve resolves every conflict with strategy s and returns the text.
+func Resolve(regions []Region, s Strategy) []string {
+	lines := []string{}
+	for _, r := range regions {
+		if !r.Conflict {
+			lines = append(lines, r.Ours...)
+			continue
+		}
+		switch s {
+		case Ours:
+			lines = append(lines, r.Ours...)
+		case Theirs:
+			lines = append(lines, r.Theirs...)
+		case Union:
+			lines = append(lines, r.Ours...)
+			lines = append(lines, r.Theirs...)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,49 @@
+package conflict
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	lines := []string{
+		"a\n",
+		"<<<<<<< ours\n", "b\n", "||||||| base\n", "c\n", "=======\n", "d\n", ">>>>>>> theirs\n",
+		"e\n",
+		"<<<<<<<\n", "f\n", "=======\n", ">>>>>>>\n",
+	}
+	regions, err := Parse(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := Conflicts(regions)
+	if len(cl) != 2 || cl[0].Start != 1 || cl[0].Count != 7 || !cl[0].HasBase || cl[0].BaseLabel != "base" || cl[1].Start != 9 || cl[1].Count != 4 || cl[1].HasBase {
+		t.Fatalf("conflicts mismatch: %+v", cl)
+	}
+	for _, tc := range []struct {
+		s     Strategy
+		lines []string
+	}{
+		{Ours, []string{"a\n", "b\n", "e\n", "f\n"}},
+		{Theirs, []string{"a\n", "d\n", "e\n"}},
+		{Union, []string{"a\n", "b\n", "d\n", "e\n", "f\n"}},
+	} {
+		r := Resolve(regions, tc.s)
+		if !reflect.DeepEqual(r, tc.lines) {
+			t.Errorf("strategy %d: RESULT:\n%q\nEXPECTED:\n%q", tc.s, r, tc.lines)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, lines := range [][]string{
+		{"<<<<<<<\n", "a\n"},
+		{"<<<<<<<\n", "a\n", ">>>>>>>\n"},
+		{"<<<<<<<\n", "<<<<<<<\n"},
+		{"<<<<<<<\n", "=======\n", "|||||||\n", ">>>>>>>\n"},
+	} {
+		if _, err := Parse(lines); err == nil {
+			t.Errorf("%q: error expected", lines)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// Transparent decompression
+//
+// Compressed input is detected by its magic bytes:
+//
+//   gzip   1f 8b 08
+//   bzip2  "BZh", block size "1" to "9", 31 41 59 26 53 59 (or the end of
+//          stream marker 17 72 45 38 50 90 for empty input)
+//   zstd   28 b5 2f fd
+//
+// Anything else is read as it is.
+
+package decompress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"io"
+)
+
+var (
+	magic_gzip        = []byte{0x1f, 0x8b, 0x08}
+	magic_bzip2       = []byte("BZh")
+	magic_bzip2_block = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	magic_bzip2_eos   = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+	magic_zstd        = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// NewReader returns a reader of the decompressed content of r, or of r
+// itself when it is not compressed.  The reader must be closed.
+func NewReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(10)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(head, magic_gzip):
+		return gzip.NewReader(br)
+	case isbzip2(head):
+		return io.NopCloser(bzip2.NewReader(br)), nil
+	case bytes.HasPrefix(head, magic_zstd):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return io.NopCloser(br), nil
+}
+
+func isbzip2(head []byte) bool {
+	if len(head) < 10 || !bytes.HasPrefix(head, magic_bzip2) || head[3] < '1' || head[3] > '9' {
+		return false
+	}
+	return bytes.Equal(head[4:], magic_bzip2_block) || bytes.Equal(head[4:], magic_bzip2_eos)
+}
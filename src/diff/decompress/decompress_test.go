@@ -0,0 +1,65 @@
+package decompress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"testing"
+)
+
+// "hello\n" compressed by bzip2, which has no writer in the standard
+// library.
+var hello_bz2 = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0xc1, 0xc0,
+	0x80, 0xe2, 0x00, 0x00, 0x01, 0x41, 0x00, 0x00, 0x10, 0x02, 0x44, 0xa0,
+	0x00, 0x30, 0xcd, 0x00, 0xc3, 0x46, 0x29, 0x97, 0x17, 0x72, 0x45, 0x38,
+	0x50, 0x90, 0xc1, 0xc0, 0x80, 0xe2,
+}
+
+func read(t *testing.T, data []byte) string {
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestNewReader(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("hello\n"))
+	zw.Close()
+
+	var zst bytes.Buffer
+	enc, err := zstd.NewWriter(&zst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc.Write([]byte("hello\n"))
+	enc.Close()
+
+	for name, data := range map[string][]byte{
+		"gzip":  gz.Bytes(),
+		"bzip2": hello_bz2,
+		"zstd":  zst.Bytes(),
+		"plain": []byte("hello\n"),
+	} {
+		if out := read(t, data); out != "hello\n" {
+			t.Errorf("%s: %q", name, out)
+		}
+	}
+}
+
+func TestNotCompressed(t *testing.T) {
+	for _, s := range []string{"", "B", "BZh is not bzip2\n", "\x1f\x8b"} {
+		if out := read(t, []byte(s)); out != s {
+			t.Errorf("%q: %q", s, out)
+		}
+	}
+}
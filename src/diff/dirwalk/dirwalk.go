@@ -0,0 +1,203 @@
+// Walk two directory trees side by side
+//
+// Entries are sorted by name in byte order, independent of the locale and of
+// the order the file system returns them.  With FoldCase, names that differ
+// only in case are matched.
+//
+// Symbolic links are followed unless NoDereference is set.  A pair of
+// directories that is an ancestor of itself on either side is reported as a
+// loop and not walked into.  Ancestors are identified with os.SameFile, so
+// loops are only detected on file systems backed by the os package.
+
+package dirwalk
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Entry is a name found in either or both directories.  A is nil when the
+// name is only in the second directory and B is nil when it is only in the
+// first directory.  Unless NoDereference is set, A or B is a symbolic link
+// only when the link is dangling.
+type Entry struct {
+	A fs.FileInfo
+	B fs.FileInfo
+	// Set for a pair of directories when the directory of that side is
+	// one of its own ancestors.
+	ALoop bool
+	BLoop bool
+}
+
+type Options struct {
+	FoldCase      bool
+	NoDereference bool
+}
+
+// WalkFunc is called for each entry with the directory containing it,
+// relative to the roots given to Walk.  Returning fs.SkipDir does not walk
+// into a pair of directories, any other error stops the walk.
+type WalkFunc func(adir string, bdir string, e Entry) error
+
+// ReadDir returns the entries of dir sorted by name.
+func ReadDir(fsys fs.FS, dir string) ([]fs.FileInfo, error) {
+	dl, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	fl := []fs.FileInfo{}
+	for _, d := range dl {
+		fi, err := d.Info()
+		if err != nil {
+			return nil, err
+		}
+		fl = append(fl, fi)
+	}
+	sort.Slice(fl, func(i, j int) bool { return fl[i].Name() < fl[j].Name() })
+	return fl, nil
+}
+
+// Join matches the entries of two directories by name.  Both lists must be
+// sorted by name.
+func Join(al []fs.FileInfo, bl []fs.FileInfo, opts Options) []Entry {
+	if opts.FoldCase {
+		al = sort_folded(al)
+		bl = sort_folded(bl)
+	}
+	el := []Entry{}
+	a := 0
+	b := 0
+	for a < len(al) || b < len(bl) {
+		if a >= len(al) {
+			el = append(el, Entry{B: bl[b]})
+			b++
+		} else if b >= len(bl) {
+			el = append(el, Entry{A: al[a]})
+			a++
+		} else if k := compare(al[a].Name(), bl[b].Name(), opts); k < 0 {
+			el = append(el, Entry{A: al[a]})
+			a++
+		} else if k > 0 {
+			el = append(el, Entry{B: bl[b]})
+			b++
+		} else {
+			el = append(el, Entry{A: al[a], B: bl[b]})
+			a++
+			b++
+		}
+	}
+	return el
+}
+
+// TypeName returns the name of the file type of fi, as printed by GNU diff.
+func TypeName(fi fs.FileInfo) string {
+	mode := fi.Mode()
+	switch {
+	case mode.IsRegular():
+		return "regular file"
+	case mode&fs.ModeDir != 0:
+		return "directory"
+	case mode&fs.ModeSymlink != 0:
+		return "symbolic link"
+	case mode&fs.ModeNamedPipe != 0:
+		return "fifo"
+	case mode&fs.ModeSocket != 0:
+		return "socket"
+	case mode&fs.ModeCharDevice != 0:
+		return "character special file"
+	case mode&fs.ModeDevice != 0:
+		return "block special file"
+	}
+	return "weird file"
+}
+
+// Walk walks the trees at adir of afs and bdir of bfs.  Pairs of directories
+// are walked after fn is called for them.
+func Walk(afs fs.FS, adir string, bfs fs.FS, bdir string, opts Options, fn WalkFunc) error {
+	ai, err := fs.Stat(afs, adir)
+	if err != nil {
+		return err
+	}
+	bi, err := fs.Stat(bfs, bdir)
+	if err != nil {
+		return err
+	}
+	return walk(afs, adir, []fs.FileInfo{ai}, bfs, bdir, []fs.FileInfo{bi}, opts, fn)
+}
+
+func walk(afs fs.FS, adir string, aancestors []fs.FileInfo, bfs fs.FS, bdir string, bancestors []fs.FileInfo, opts Options, fn WalkFunc) error {
+	al, err := readdir(afs, adir, opts)
+	if err != nil {
+		return err
+	}
+	bl, err := readdir(bfs, bdir, opts)
+	if err != nil {
+		return err
+	}
+	for _, e := range Join(al, bl, opts) {
+		dirs := e.A != nil && e.B != nil && e.A.IsDir() && e.B.IsDir()
+		if dirs {
+			e.ALoop = contains(aancestors, e.A)
+			e.BLoop = contains(bancestors, e.B)
+		}
+		err := fn(adir, bdir, e)
+		if err == fs.SkipDir {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if dirs && !e.ALoop && !e.BLoop {
+			err = walk(afs, path.Join(adir, e.A.Name()), append(aancestors, e.A), bfs, path.Join(bdir, e.B.Name()), append(bancestors, e.B), opts, fn)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Read dir and follow the symbolic links in it unless NoDereference is set.
+// A link that cannot be followed is kept as it is.
+func readdir(fsys fs.FS, dir string, opts Options) ([]fs.FileInfo, error) {
+	fl, err := ReadDir(fsys, dir)
+	if err != nil || opts.NoDereference {
+		return fl, err
+	}
+	for i, fi := range fl {
+		if fi.Mode()&fs.ModeSymlink == 0 {
+			continue
+		}
+		if ti, err := fs.Stat(fsys, path.Join(dir, fi.Name())); err == nil {
+			fl[i] = ti
+		}
+	}
+	return fl, nil
+}
+
+func contains(ancestors []fs.FileInfo, fi fs.FileInfo) bool {
+	for _, a := range ancestors {
+		if os.SameFile(a, fi) {
+			return true
+		}
+	}
+	return false
+}
+
+func sort_folded(fl []fs.FileInfo) []fs.FileInfo {
+	r := append([]fs.FileInfo{}, fl...)
+	sort.SliceStable(r, func(i, j int) bool {
+		return strings.ToLower(r[i].Name()) < strings.ToLower(r[j].Name())
+	})
+	return r
+}
+
+func compare(a string, b string, opts Options) int {
+	if opts.FoldCase {
+		a = strings.ToLower(a)
+		b = strings.ToLower(b)
+	}
+	return strings.Compare(a, b)
+}
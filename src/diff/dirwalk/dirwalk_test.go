@@ -0,0 +1,170 @@
+package dirwalk
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+// Record the walk as "adir bdir name-in-a name-in-b" with "-" for a missing
+// side.
+func record(afs fs.FS, bfs fs.FS, opts Options, skip string) ([]string, error) {
+	out := []string{}
+	err := Walk(afs, ".", bfs, ".", opts, func(adir string, bdir string, e Entry) error {
+		an := "-"
+		bn := "-"
+		if e.A != nil {
+			an = e.A.Name()
+		}
+		if e.B != nil {
+			bn = e.B.Name()
+		}
+		out = append(out, fmt.Sprintf("%s %s %s %s", adir, bdir, an, bn))
+		if an == skip {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	return out, err
+}
+
+func TestReadDir(t *testing.T) {
+	fsys := fstest.MapFS{
+		"c":   {},
+		"B":   {},
+		"a/x": {},
+		"b":   {},
+	}
+	fl, err := ReadDir(fsys, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, fi := range fl {
+		names = append(names, fi.Name())
+	}
+	expected := []string{"B", "a", "b", "c"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("RESULT: %q EXPECTED: %q", names, expected)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	afs := fstest.MapFS{
+		"a":       {},
+		"d/x":     {},
+		"d/e/y":   {},
+		"f":       {},
+		"only_a/": {Mode: fs.ModeDir},
+		"z":       {},
+	}
+	bfs := fstest.MapFS{
+		"b":     {},
+		"d/e/y": {},
+		"d/w":   {},
+		"f":     {},
+		"z/":    {Mode: fs.ModeDir},
+	}
+	out, err := record(afs, bfs, Options{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		". . a -",
+		". . - b",
+		". . d d",
+		"d d e e",
+		"d/e d/e y y",
+		"d d - w",
+		"d d x -",
+		". . f f",
+		". . only_a -",
+		". . z z",
+	}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("walk mismatch:\nRESULT:\n%q\nEXPECTED:\n%q", out, expected)
+	}
+}
+
+func TestWalkSkipDir(t *testing.T) {
+	afs := fstest.MapFS{"d/x": {}, "e/y": {}}
+	bfs := fstest.MapFS{"d/x": {}, "e/y": {}}
+	out, err := record(afs, bfs, Options{}, "d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{". . d d", ". . e e", "e e y y"}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("walk mismatch:\nRESULT:\n%q\nEXPECTED:\n%q", out, expected)
+	}
+}
+
+func TestWalkFoldCase(t *testing.T) {
+	afs := fstest.MapFS{"Makefile": {}, "README": {}, "Src/a": {}, "b": {}}
+	bfs := fstest.MapFS{"B": {}, "makefile": {}, "readme": {}, "src/a": {}}
+	out, err := record(afs, bfs, Options{FoldCase: true}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		". . b B",
+		". . Makefile makefile",
+		". . README readme",
+		". . Src src",
+		"Src src a a",
+	}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("walk mismatch:\nRESULT:\n%q\nEXPECTED:\n%q", out, expected)
+	}
+	out, err = record(afs, bfs, Options{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 8 {
+		t.Errorf("names must not match without FoldCase: %q", out)
+	}
+}
+
+func TestWalkError(t *testing.T) {
+	afs := fstest.MapFS{"a": {}, "b": {}}
+	bfs := fstest.MapFS{"a": {}, "b": {}}
+	stop := errors.New("stop")
+	n := 0
+	err := Walk(afs, ".", bfs, ".", Options{}, func(adir string, bdir string, e Entry) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Errorf("err = %v, n = %d", err, n)
+	}
+	if err := Walk(afs, "missing", bfs, ".", Options{}, nil); err == nil {
+		t.Error("error expected for a missing directory")
+	}
+}
+
+func TestTypeName(t *testing.T) {
+	fsys := fstest.MapFS{
+		"b": {Mode: fs.ModeDevice},
+		"c": {Mode: fs.ModeDevice | fs.ModeCharDevice},
+		"d": {Mode: fs.ModeDir},
+		"f": {},
+		"l": {Mode: fs.ModeSymlink},
+		"p": {Mode: fs.ModeNamedPipe},
+		"s": {Mode: fs.ModeSocket},
+	}
+	expected := []string{"block special file", "character special file", "directory", "regular file", "symbolic link", "fifo", "socket"}
+	fl, err := ReadDir(fsys, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, fi := range fl {
+		names = append(names, TypeName(fi))
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("RESULT: %q EXPECTED: %q", names, expected)
+	}
+}
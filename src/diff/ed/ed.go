@@ -0,0 +1,253 @@
+// Ed script generation and application
+// http://pubs.opengroup.org/onlinepubs/9699919799/utilities/ed.html
+//
+// Only the subset written by "diff -e" and "diff -f" is supported:
+//
+//   -e  N[,M]a  N[,M]c  N[,M]d
+//       Commands are in decreasing line order, so each address refers to
+//       the text as modified by the preceding commands.
+//   -f  aN  cN[ M]  dN[ M]
+//       Commands are in increasing line order and addresses refer to the
+//       original text.
+//
+// Text for a and c is terminated by a line containing a single ".".  As in
+// GNU diff, a text line that is a single "." is written as "..", and the text
+// is terminated and followed by
+//
+//   s/.//   remove the first character of the current line
+//   a       append the rest of the text after the current line
+
+package ed
+
+import (
+	"fmt"
+	"github.com/hattya/go.diff"
+	"strconv"
+	"strings"
+)
+
+// Script returns the ed script ("diff -e") that turns the first text into bl.
+func Script(cl []diff.Change, bl []string) []string {
+	script := []string{}
+	for i := len(cl) - 1; i >= 0; i-- {
+		c := cl[i]
+		if c.Del == 0 {
+			script = append(script, fmt.Sprintf("%sa\n", format_range(c.A, c.Del, ",")))
+			script = append(script, text(bl[c.B:c.B+c.Ins])...)
+		} else if c.Ins == 0 {
+			script = append(script, fmt.Sprintf("%sd\n", format_range(c.A, c.Del, ",")))
+		} else {
+			script = append(script, fmt.Sprintf("%sc\n", format_range(c.A, c.Del, ",")))
+			script = append(script, text(bl[c.B:c.B+c.Ins])...)
+		}
+	}
+	return script
+}
+
+// AltScript returns the alternative form of ed script ("diff -f").
+func AltScript(cl []diff.Change, bl []string) []string {
+	script := []string{}
+	for _, c := range cl {
+		if c.Del == 0 {
+			script = append(script, fmt.Sprintf("a%s\n", format_range(c.A, c.Del, " ")))
+			script = append(script, text(bl[c.B:c.B+c.Ins])...)
+		} else if c.Ins == 0 {
+			script = append(script, fmt.Sprintf("d%s\n", format_range(c.A, c.Del, " ")))
+		} else {
+			script = append(script, fmt.Sprintf("c%s\n", format_range(c.A, c.Del, " ")))
+			script = append(script, text(bl[c.B:c.B+c.Ins])...)
+		}
+	}
+	return script
+}
+
+func format_range(start int, count int, sep string) string {
+	base := 1
+	if count == 0 {
+		return fmt.Sprintf("%d", start)
+	} else if count == 1 {
+		return fmt.Sprintf("%d", base+start)
+	} else {
+		return fmt.Sprintf("%d%s%d", base+start, sep, base+start+count-1)
+	}
+}
+
+func text(lines []string) []string {
+	t := []string{}
+	for i, line := range lines {
+		if !strings.HasSuffix(line, "\n") {
+			line += "\n"
+		}
+		if line != ".\n" {
+			t = append(t, line)
+			continue
+		}
+		t = append(t, "..\n", ".\n", "s/.//\n")
+		if i+1 == len(lines) {
+			return t
+		}
+		t = append(t, "a\n")
+	}
+	return append(t, ".\n")
+}
+
+// Apply applies an ed script ("diff -e") to lines and returns the new text.
+func Apply(lines []string, script []string) ([]string, error) {
+	buf := append([]string{}, lines...)
+	// The current line, one based.
+	cur := len(buf)
+	i := 0
+	for i < len(script) {
+		line := strings.TrimSuffix(script[i], "\n")
+		if line == "" {
+			return nil, fmt.Errorf("script line %d: empty command", i+1)
+		}
+		if line == "s/.//" {
+			if cur < 1 || cur > len(buf) || !strings.HasPrefix(buf[cur-1], ".") {
+				return nil, fmt.Errorf("script line %d: substitution failed", i+1)
+			}
+			buf[cur-1] = buf[cur-1][1:]
+			i++
+			continue
+		}
+		cmd := line[len(line)-1]
+		if strings.IndexByte("acd", cmd) == -1 {
+			return nil, fmt.Errorf("script line %d: unknown command %q", i+1, line)
+		}
+		start, end := cur, cur
+		var err error
+		if line != "a" {
+			start, end, err = parse_range(line[:len(line)-1], ",")
+			if err != nil {
+				return nil, fmt.Errorf("script line %d: %s", i+1, err)
+			}
+		}
+		i++
+		var t []string
+		if cmd == 'a' || cmd == 'c' {
+			t, i, err = read_text(script, i)
+			if err != nil {
+				return nil, err
+			}
+		}
+		switch cmd {
+		case 'a':
+			if start != end || start > len(buf) {
+				return nil, fmt.Errorf("script line %d: invalid address %q", i, line)
+			}
+			buf = splice(buf, start, start, t)
+			cur = start + len(t)
+		default:
+			if start < 1 || end > len(buf) {
+				return nil, fmt.Errorf("script line %d: invalid address %q", i, line)
+			}
+			buf = splice(buf, start-1, end, t)
+			cur = start - 1 + len(t)
+		}
+	}
+	return buf, nil
+}
+
+// ApplyAlt applies an alternative form of ed script ("diff -f") to lines and
+// returns the new text.
+func ApplyAlt(lines []string, script []string) ([]string, error) {
+	out := []string{}
+	pos := 0
+	i := 0
+	for i < len(script) {
+		line := strings.TrimSuffix(script[i], "\n")
+		if line == "" {
+			return nil, fmt.Errorf("script line %d: empty command", i+1)
+		}
+		if line == "s/.//" {
+			// The current line is the last line of the text.
+			if len(out) == 0 || !strings.HasPrefix(out[len(out)-1], ".") {
+				return nil, fmt.Errorf("script line %d: substitution failed", i+1)
+			}
+			out[len(out)-1] = out[len(out)-1][1:]
+			i++
+			continue
+		}
+		cmd := line[0]
+		if strings.IndexByte("acd", cmd) == -1 {
+			return nil, fmt.Errorf("script line %d: unknown command %q", i+1, line)
+		}
+		if line == "a" {
+			// More text after the current line.
+			t, next, err := read_text(script, i+1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, t...)
+			i = next
+			continue
+		}
+		start, end, err := parse_range(line[1:], " ")
+		if err != nil {
+			return nil, fmt.Errorf("script line %d: %s", i+1, err)
+		}
+		i++
+		var t []string
+		if cmd == 'a' || cmd == 'c' {
+			t, i, err = read_text(script, i)
+			if err != nil {
+				return nil, err
+			}
+		}
+		switch cmd {
+		case 'a':
+			if start != end || start < pos || start > len(lines) {
+				return nil, fmt.Errorf("script line %d: invalid address %q", i, line)
+			}
+			out = append(out, lines[pos:start]...)
+			out = append(out, t...)
+			pos = start
+		default:
+			if start < 1 || start-1 < pos || end > len(lines) {
+				return nil, fmt.Errorf("script line %d: invalid address %q", i, line)
+			}
+			out = append(out, lines[pos:start-1]...)
+			out = append(out, t...)
+			pos = end
+		}
+	}
+	out = append(out, lines[pos:]...)
+	return out, nil
+}
+
+// Parse "N" or "N<sep>M".
+func parse_range(s string, sep string) (int, int, error) {
+	args := strings.Split(s, sep)
+	if len(args) > 2 {
+		return 0, 0, fmt.Errorf("invalid address %q", s)
+	}
+	start, err := strconv.Atoi(args[0])
+	if err != nil || start < 0 {
+		return 0, 0, fmt.Errorf("invalid address %q", s)
+	}
+	end := start
+	if len(args) == 2 {
+		end, err = strconv.Atoi(args[1])
+		if err != nil || end < start {
+			return 0, 0, fmt.Errorf("invalid address %q", s)
+		}
+	}
+	return start, end, nil
+}
+
+// Read text lines up to the terminating ".".
+func read_text(script []string, i int) ([]string, int, error) {
+	start := i
+	for ; i < len(script); i++ {
+		if strings.TrimSuffix(script[i], "\n") == "." {
+			return script[start:i], i + 1, nil
+		}
+	}
+	return nil, i, fmt.Errorf("script line %d: text is not terminated by '.'", start)
+}
+
+func splice(buf []string, start int, end int, t []string) []string {
+	r := append([]string{}, buf[:start]...)
+	r = append(r, t...)
+	return append(r, buf[end:]...)
+}
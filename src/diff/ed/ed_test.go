@@ -0,0 +1,102 @@
+package ed
+
+import (
+	"github.com/hattya/go.diff"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var al = []string{"a\n", "b\n", "c\n", "d\n"}
+var bl = []string{"x\n", "a\n", "c\n", "y\n", "z\n"}
+var cl = []diff.Change{{A: 0, B: 0, Del: 0, Ins: 1}, {A: 1, B: 2, Del: 1, Ins: 0}, {A: 3, B: 3, Del: 1, Ins: 2}}
+
+// A lone "." is added, changed to, and added in the middle of the text.
+var dl = []string{"a\n", ".\n", "x\n", "..\n", "c\n", ".\n"}
+var dcl = []diff.Change{{A: 1, B: 1, Del: 1, Ins: 3}, {A: 3, B: 5, Del: 0, Ins: 1}}
+
+func TestScript(t *testing.T) {
+	expected := "4c\ny\nz\n.\n2d\n0a\nx\n.\n"
+	if s := strings.Join(Script(cl, bl), ""); s != expected {
+		t.Errorf("RESULT: %q EXPECTED: %q", s, expected)
+	}
+	// The output of GNU diff.
+	expected = "3a\n..\n.\ns/.//\n2c\n..\n.\ns/.//\na\nx\n..\n.\n"
+	if s := strings.Join(Script(dcl, dl), ""); s != expected {
+		t.Errorf("RESULT: %q EXPECTED: %q", s, expected)
+	}
+}
+
+func TestAltScript(t *testing.T) {
+	expected := "a0\nx\n.\nd2\nc4\ny\nz\n.\n"
+	if s := strings.Join(AltScript(cl, bl), ""); s != expected {
+		t.Errorf("RESULT: %q EXPECTED: %q", s, expected)
+	}
+	expected = "c2\n..\n.\ns/.//\na\nx\n..\n.\na3\n..\n.\ns/.//\n"
+	if s := strings.Join(AltScript(dcl, dl), ""); s != expected {
+		t.Errorf("RESULT: %q EXPECTED: %q", s, expected)
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		al, bl []string
+		cl     []diff.Change
+	}{
+		{al, bl, cl},
+		{[]string{"a\n", "b\n", "c\n"}, dl, dcl},
+		{nil, []string{".\n"}, []diff.Change{{A: 0, B: 0, Del: 0, Ins: 1}}},
+		{[]string{"a\n"}, []string{"a\n", "b"}, []diff.Change{{A: 1, B: 1, Del: 0, Ins: 1}}},
+	}
+	for _, tt := range tests {
+		if r, err := Apply(tt.al, Script(tt.cl, tt.bl)); err != nil {
+			t.Error(err)
+		} else if !reflect.DeepEqual(r, complete(tt.bl)) {
+			t.Errorf("Apply: RESULT: %q EXPECTED: %q", r, tt.bl)
+		}
+		if r, err := ApplyAlt(tt.al, AltScript(tt.cl, tt.bl)); err != nil {
+			t.Error(err)
+		} else if !reflect.DeepEqual(r, complete(tt.bl)) {
+			t.Errorf("ApplyAlt: RESULT: %q EXPECTED: %q", r, tt.bl)
+		}
+	}
+}
+
+func TestApplyError(t *testing.T) {
+	for _, script := range [][]string{
+		{"\n"},
+		{"1x\n"},
+		{"x,1d\n"},
+		{"9d\n"},
+		{"1a\n", "x\n"},
+		{"s/.//\n"},
+	} {
+		if _, err := Apply(al, script); err == nil {
+			t.Errorf("Apply: %q: error expected", script)
+		}
+	}
+	for _, script := range [][]string{
+		{"\n"},
+		{"x1\n"},
+		{"d9\n"},
+		{"d2\n", "d1\n"},
+		{"a1\n", "x\n"},
+		{"s/.//\n"},
+	} {
+		if _, err := ApplyAlt(al, script); err == nil {
+			t.Errorf("ApplyAlt: %q: error expected", script)
+		}
+	}
+}
+
+// Text in scripts always ends with a newline.
+func complete(lines []string) []string {
+	r := []string{}
+	for _, line := range lines {
+		if !strings.HasSuffix(line, "\n") {
+			line += "\n"
+		}
+		r = append(r, line)
+	}
+	return r
+}
@@ -0,0 +1,200 @@
+// Command line parser in the style of getopt_long
+//
+// Options are the flags of a flag.FlagSet:
+//
+//   -abc          bundled flags with single letter names
+//   -U5, -U 5     a single letter flag with an attached or separate value
+//   --name=value  a flag, or an alias, given by name or by a unique prefix
+//   --name value
+//   -name=value   a flag given by its full name, as with the flag package
+//   --            the end of options
+//
+// Operands and options can be mixed, the operands are returned in order.
+// "-" is an operand.
+
+package getopt
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Alias is a long name of flags.  Flag is set to true when no value is
+// given, and ArgFlag is set to the value when one is given.  Either may be
+// empty.
+type Alias struct {
+	Long    string
+	Flag    string
+	ArgFlag string
+}
+
+// Option is an option as parsed, with the name of the flag it set.
+type Option struct {
+	Name  string
+	Value string
+}
+
+// String formats o as -name or -name=value, which Parse accepts.
+func (o Option) String() string {
+	if o.Value == "true" {
+		return "-" + o.Name
+	}
+	return fmt.Sprintf("-%s=%s", o.Name, o.Value)
+}
+
+type Parser struct {
+	Flags   *flag.FlagSet
+	Aliases []Alias
+}
+
+// Parse sets the flags from args and returns the operands and the options
+// in the order they were given.  An unknown -h or --help returns
+// flag.ErrHelp.
+func (p *Parser) Parse(args []string) ([]string, []Option, error) {
+	operands := []string{}
+	options := []Option{}
+	set := func(name string, value string, opt string) error {
+		if err := p.Flags.Set(name, value); err != nil {
+			return fmt.Errorf("invalid argument '%s' for '%s': %s", value, opt, err)
+		}
+		options = append(options, Option{name, value})
+		return nil
+	}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return append(operands, args[i+1:]...), options, nil
+		case arg == "-" || !strings.HasPrefix(arg, "-"):
+			operands = append(operands, arg)
+			continue
+		}
+
+		var name, value, opt string
+		hasvalue := false
+		if strings.HasPrefix(arg, "--") {
+			name = arg[2:]
+			if j := strings.IndexByte(name, '='); j != -1 {
+				name, value, hasvalue = name[:j], name[j+1:], true
+			}
+			var err error
+			opt = "--" + name
+			name, err = p.long(name, hasvalue)
+			if err != nil {
+				return nil, nil, err
+			}
+		} else {
+			name = arg[1:]
+			if j := strings.IndexByte(name, '='); j != -1 {
+				name, value, hasvalue = name[:j], name[j+1:], true
+			}
+			opt = "-" + name
+			if p.Flags.Lookup(name) == nil {
+				if name == "h" || name == "help" {
+					return nil, nil, flag.ErrHelp
+				}
+				n, err := p.short(arg[1:], args[i+1:], set)
+				if err != nil {
+					return nil, nil, err
+				}
+				i += n
+				continue
+			}
+		}
+
+		if isbool(p.Flags.Lookup(name)) {
+			if !hasvalue {
+				value = "true"
+			}
+		} else if !hasvalue {
+			if i+1 == len(args) {
+				return nil, nil, fmt.Errorf("option '%s' requires an argument", opt)
+			}
+			i++
+			value = args[i]
+		}
+		if err := set(name, value, opt); err != nil {
+			return nil, nil, err
+		}
+	}
+	return operands, options, nil
+}
+
+// Resolve a long name or an unambiguous prefix of one to the name of a
+// flag.
+func (p *Parser) long(name string, hasvalue bool) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("unrecognized option '--'")
+	}
+	names := map[string]Alias{}
+	p.Flags.VisitAll(func(f *flag.Flag) {
+		if len(f.Name) > 1 {
+			names[f.Name] = Alias{Long: f.Name, Flag: f.Name, ArgFlag: f.Name}
+		}
+	})
+	for _, a := range p.Aliases {
+		names[a.Long] = a
+	}
+	a, ok := names[name]
+	if !ok {
+		candidates := []string{}
+		for long := range names {
+			if strings.HasPrefix(long, name) {
+				candidates = append(candidates, long)
+			}
+		}
+		sort.Strings(candidates)
+		switch len(candidates) {
+		case 0:
+			if name == "help" {
+				return "", flag.ErrHelp
+			}
+			return "", fmt.Errorf("unrecognized option '--%s'", name)
+		case 1:
+			a = names[candidates[0]]
+		default:
+			return "", fmt.Errorf("option '--%s' is ambiguous; possibilities: '--%s'", name, strings.Join(candidates, "' '--"))
+		}
+	}
+	if hasvalue && a.ArgFlag != "" {
+		return a.ArgFlag, nil
+	} else if !hasvalue && a.Flag != "" {
+		return a.Flag, nil
+	} else if hasvalue {
+		return "", fmt.Errorf("option '--%s' doesn't allow an argument", a.Long)
+	}
+	// A flag that needs a value which is the next argument.
+	return a.ArgFlag, nil
+}
+
+// Parse a cluster of single letter flags and return the number of arguments
+// that were consumed from rest.
+func (p *Parser) short(cluster string, rest []string, set func(string, string, string) error) (int, error) {
+	for j := 0; j < len(cluster); j++ {
+		name := cluster[j : j+1]
+		f := p.Flags.Lookup(name)
+		if f == nil {
+			return 0, fmt.Errorf("invalid option -- '%s'", name)
+		}
+		if isbool(f) {
+			if err := set(name, "true", "-"+name); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if j+1 < len(cluster) {
+			return 0, set(name, cluster[j+1:], "-"+name)
+		} else if len(rest) == 0 {
+			return 0, fmt.Errorf("option requires an argument -- '%s'", name)
+		}
+		return 1, set(name, rest[0], "-"+name)
+	}
+	return 0, nil
+}
+
+func isbool(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
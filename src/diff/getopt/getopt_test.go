@@ -0,0 +1,93 @@
+package getopt
+
+import (
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newparser() (*Parser, *bool, *bool, *int, *string) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	r := fs.Bool("r", false, "")
+	u := fs.Bool("u", false, "")
+	U := fs.Int("U", 0, "")
+	label := fs.String("label", "", "")
+	aliases := []Alias{
+		{Long: "recursive", Flag: "r"},
+		{Long: "unified", Flag: "u", ArgFlag: "U"},
+	}
+	return &Parser{Flags: fs, Aliases: aliases}, r, u, U, label
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		args     []string
+		r, u     bool
+		U        int
+		label    string
+		operands []string
+		options  string
+	}{
+		{[]string{"-ru", "a", "b"}, true, true, 0, "", []string{"a", "b"}, "-r -u"},
+		{[]string{"a", "-U5", "b"}, false, false, 5, "", []string{"a", "b"}, "-U=5"},
+		{[]string{"-U", "5", "-", "b"}, false, false, 5, "", []string{"-", "b"}, "-U=5"},
+		{[]string{"-rU", "2", "a"}, true, false, 2, "", []string{"a"}, "-r -U=2"},
+		{[]string{"--unified", "--recursive", "a"}, true, true, 0, "", []string{"a"}, "-u -r"},
+		{[]string{"--unified=7", "--lab", "x y"}, false, false, 7, "x y", []string{}, "-U=7 -label=x y"},
+		{[]string{"--label=", "--", "-r", "--u"}, false, false, 0, "", []string{"-r", "--u"}, "-label="},
+		{[]string{"-label", "l", "-U=3", "-r=false"}, false, false, 3, "l", []string{}, "-label=l -U=3 -r=false"},
+	}
+	for _, tt := range tests {
+		p, r, u, U, label := newparser()
+		operands, options, err := p.Parse(tt.args)
+		if err != nil {
+			t.Errorf("%q: %v", tt.args, err)
+			continue
+		}
+		ol := []string{}
+		for _, o := range options {
+			ol = append(ol, o.String())
+		}
+		if *r != tt.r || *u != tt.u || *U != tt.U || *label != tt.label {
+			t.Errorf("%q: r=%v u=%v U=%d label=%q", tt.args, *r, *u, *U, *label)
+		}
+		if !reflect.DeepEqual(operands, tt.operands) {
+			t.Errorf("%q: operands = %q, expected %q", tt.args, operands, tt.operands)
+		}
+		if strings.Join(ol, " ") != tt.options {
+			t.Errorf("%q: options = %q, expected %q", tt.args, strings.Join(ol, " "), tt.options)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"-x"}, "invalid option -- 'x'"},
+		{[]string{"-rx"}, "invalid option -- 'x'"},
+		{[]string{"-U"}, "option '-U' requires an argument"},
+		{[]string{"-rU"}, "option requires an argument -- 'U'"},
+		{[]string{"--label"}, "option '--label' requires an argument"},
+		{[]string{"--foo"}, "unrecognized option '--foo'"},
+		{[]string{"--u"}, "option '--u' is ambiguous; possibilities: '--unified' '--unmatched'"},
+		{[]string{"--recursive=yes"}, "option '--recursive' doesn't allow an argument"},
+		{[]string{"-Ux"}, "invalid argument 'x' for '-U': parse error"},
+	}
+	for _, tt := range tests {
+		p, _, _, _, _ := newparser()
+		p.Aliases = append(p.Aliases, Alias{Long: "unmatched", Flag: "u"})
+		_, _, err := p.Parse(tt.args)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q: err = %v, expected %q", tt.args, err, tt.err)
+		}
+	}
+	for _, args := range [][]string{{"-h"}, {"--help"}, {"-help"}} {
+		p, _, _, _, _ := newparser()
+		if _, _, err := p.Parse(args); err != flag.ErrHelp {
+			t.Errorf("%q: err = %v, expected flag.ErrHelp", args, err)
+		}
+	}
+}
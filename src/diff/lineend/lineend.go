@@ -0,0 +1,102 @@
+// Line endings
+//
+// Lines keep their ending, so that output reproduces the original text.  The
+// mode selects which endings end a line:
+//
+//   LF   "\n", or "\r\n" as a whole; a lone "\r" is part of the line
+//   Any  "\n", "\r\n" or "\r"
+//
+// The last line of a text may have no ending.
+
+package lineend
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+type Mode int
+
+const (
+	LF Mode = iota
+	Any
+)
+
+func ParseMode(s string) (Mode, error) {
+	switch s {
+	case "lf":
+		return LF, nil
+	case "any":
+		return Any, nil
+	}
+	return LF, fmt.Errorf("invalid line ending mode '%s'", s)
+}
+
+// Split reads r and splits it into lines.
+func Split(r io.Reader, mode Mode) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	s := string(data)
+	lines := []string{}
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\n' {
+			lines = append(lines, s[start:i+1])
+			start = i + 1
+		} else if s[i] == '\r' && mode == Any && (i+1 == len(s) || s[i+1] != '\n') {
+			lines = append(lines, s[start:i+1])
+			start = i + 1
+		}
+	}
+	if start < len(s) {
+		lines = append(lines, s[start:])
+	}
+	return lines, nil
+}
+
+// Ending returns the ending of line, "" if it has none.
+func Ending(line string, mode Mode) string {
+	if strings.HasSuffix(line, "\r\n") {
+		return "\r\n"
+	} else if strings.HasSuffix(line, "\n") {
+		return "\n"
+	} else if strings.HasSuffix(line, "\r") && mode == Any {
+		return "\r"
+	}
+	return ""
+}
+
+// Trim returns line without its ending.
+func Trim(line string, mode Mode) string {
+	return line[:len(line)-len(Ending(line, mode))]
+}
+
+// Normalize returns line with its ending replaced by "\n".  A missing
+// ending on the last line is kept missing.
+func Normalize(line string, mode Mode) string {
+	if Ending(line, mode) == "" {
+		return line
+	}
+	return Trim(line, mode) + "\n"
+}
+
+// OnlyEndingsDiffer reports whether al and bl differ, but only in the
+// endings of their lines.
+func OnlyEndingsDiffer(al []string, bl []string, mode Mode) bool {
+	if len(al) != len(bl) {
+		return false
+	}
+	differ := false
+	for i := range al {
+		if Normalize(al[i], mode) != Normalize(bl[i], mode) {
+			return false
+		}
+		if al[i] != bl[i] {
+			differ = true
+		}
+	}
+	return differ
+}
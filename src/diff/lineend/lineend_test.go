@@ -0,0 +1,65 @@
+package lineend
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	text := "a\nb\r\nc\rd\r\re"
+	for _, c := range []struct {
+		mode     Mode
+		expected []string
+	}{
+		{LF, []string{"a\n", "b\r\n", "c\rd\r\re"}},
+		{Any, []string{"a\n", "b\r\n", "c\r", "d\r", "\r", "e"}},
+	} {
+		lines, err := Split(strings.NewReader(text), c.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(lines, c.expected) {
+			t.Errorf("mode %d: RESULT %q EXPECTED %q", c.mode, lines, c.expected)
+		}
+	}
+	if lines, _ := Split(strings.NewReader("a\r"), Any); !reflect.DeepEqual(lines, []string{"a\r"}) {
+		t.Errorf("trailing CR: %q", lines)
+	}
+}
+
+func TestEnding(t *testing.T) {
+	for _, c := range []struct {
+		line string
+		mode Mode
+		end  string
+	}{
+		{"a\n", LF, "\n"},
+		{"a\r\n", LF, "\r\n"},
+		{"a\r", LF, ""},
+		{"a\r", Any, "\r"},
+		{"a", Any, ""},
+	} {
+		if end := Ending(c.line, c.mode); end != c.end {
+			t.Errorf("%q mode %d: %q", c.line, c.mode, end)
+		}
+	}
+}
+
+func TestOnlyEndingsDiffer(t *testing.T) {
+	for _, c := range []struct {
+		al       []string
+		bl       []string
+		expected bool
+	}{
+		{[]string{"a\r\n", "b\r"}, []string{"a\n", "b\n"}, true},
+		{[]string{"a\n"}, []string{"a\n"}, false},
+		{[]string{"a\n"}, []string{"a"}, false},
+		{[]string{"a\r\n"}, []string{"b\n"}, false},
+		{[]string{"a\n"}, []string{"a\n", "b\n"}, false},
+	} {
+		if r := OnlyEndingsDiffer(c.al, c.bl, Any); r != c.expected {
+			t.Errorf("%q %q: %v", c.al, c.bl, r)
+		}
+	}
+}
@@ -0,0 +1,430 @@
+// GNU diff line and group formats
+// https://www.gnu.org/software/diffutils/manual/html_node/Line-Group-Formats.html
+// https://www.gnu.org/software/diffutils/manual/html_node/Line-Formats.html
+//
+// A group format is applied to each group of old, new, changed or unchanged
+// lines.  It may contain:
+//
+//   %<        lines from the first file, each formatted by OldLine
+//   %>        lines from the second file, each formatted by NewLine
+//   %=        lines common to both files, each formatted by UnchangedLine
+//   %%        a single %
+//   %c'C'     the character C (C may be an octal escape \OOO)
+//   %[spec]V  a line number printed with printf spec [-'0 +#][width][.prec]
+//             and one of d, o, x, X.  V is one of e, f, l, m, n for the
+//             first file and E, F, L, M, N for the second file:
+//               e  line number just before the group
+//               f  first line number of the group
+//               l  last line number of the group
+//               m  line number just after the group
+//               n  number of lines in the group
+//   %(A=B?T:E) T if A equals B, else E.  A and B are a decimal number or a
+//             single variable letter, T and E are formats.
+//
+// A line format may contain %l (the line without its trailing newline),
+// %L (the line as is), %%, %c'C', %[spec]n (the line number) and
+// %(A=B?T:E).
+
+package lineformat
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/hattya/go.diff"
+	"io"
+	"strings"
+)
+
+type Formats struct {
+	OldGroup       string
+	NewGroup       string
+	UnchangedGroup string
+	ChangedGroup   string
+	OldLine        string
+	NewLine        string
+	UnchangedLine  string
+}
+
+// Default formats print every line of both files without decoration.
+func Default() Formats {
+	return Formats{
+		OldGroup:       "%<",
+		NewGroup:       "%>",
+		UnchangedGroup: "%=",
+		ChangedGroup:   "%<%>",
+		OldLine:        "%l\n",
+		NewLine:        "%l\n",
+		UnchangedLine:  "%l\n",
+	}
+}
+
+// Formats for the merged output of "diff -D NAME".
+func Ifdef(name string) Formats {
+	f := Default()
+	f.OldGroup = fmt.Sprintf("#ifndef %s\n%%<#endif /* ! %s */\n", escape(name), escape(name))
+	f.NewGroup = fmt.Sprintf("#ifdef %s\n%%>#endif /* %s */\n", escape(name), escape(name))
+	f.ChangedGroup = fmt.Sprintf("#ifndef %s\n%%<#else /* %s */\n%%>#endif /* %s */\n", escape(name), escape(name), escape(name))
+	return f
+}
+
+func escape(s string) string {
+	return strings.Replace(s, "%", "%%", -1)
+}
+
+type compiled struct {
+	oldgroup       []node
+	newgroup       []node
+	unchangedgroup []node
+	changedgroup   []node
+	oldline        []node
+	newline        []node
+	unchangedline  []node
+}
+
+// Write formats the change list cl of al and bl.
+func Write(w io.Writer, cl []diff.Change, al []string, bl []string, f Formats) error {
+	return WriteLines(w, cl, al, 0, len(al), bl, 0, len(bl), f)
+}
+
+// WriteLines formats the change list cl of lines [astart, aend) of al and
+// [bstart, bend) of bl.  Line numbers still refer to al and bl.
+func WriteLines(w io.Writer, cl []diff.Change, al []string, astart int, aend int, bl []string, bstart int, bend int, f Formats) error {
+	cf, err := compile(f)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	x := &executor{w: bw, f: cf, al: al, bl: bl}
+	a := astart
+	b := bstart
+	for _, c := range cl {
+		if a < c.A {
+			x.group(cf.unchangedgroup, a, c.A, b, c.B)
+		}
+		if c.Del == 0 {
+			x.group(cf.newgroup, c.A, c.A, c.B, c.B+c.Ins)
+		} else if c.Ins == 0 {
+			x.group(cf.oldgroup, c.A, c.A+c.Del, c.B, c.B)
+		} else {
+			x.group(cf.changedgroup, c.A, c.A+c.Del, c.B, c.B+c.Ins)
+		}
+		a = c.A + c.Del
+		b = c.B + c.Ins
+	}
+	if a < aend {
+		x.group(cf.unchangedgroup, a, aend, b, bend)
+	}
+	return bw.Flush()
+}
+
+func compile(f Formats) (*compiled, error) {
+	cf := &compiled{}
+	for _, p := range []struct {
+		format string
+		group  bool
+		nodes  *[]node
+	}{
+		{f.OldGroup, true, &cf.oldgroup},
+		{f.NewGroup, true, &cf.newgroup},
+		{f.UnchangedGroup, true, &cf.unchangedgroup},
+		{f.ChangedGroup, true, &cf.changedgroup},
+		{f.OldLine, false, &cf.oldline},
+		{f.NewLine, false, &cf.newline},
+		{f.UnchangedLine, false, &cf.unchangedline},
+	} {
+		ps := &parser{s: p.format, group: p.group}
+		nodes, err := ps.parse("")
+		if err != nil {
+			return nil, err
+		}
+		if ps.pos != len(ps.s) {
+			return nil, ps.errorf("unexpected '%c'", ps.s[ps.pos])
+		}
+		*p.nodes = nodes
+	}
+	return cf, nil
+}
+
+type node interface{}
+
+type literal string
+
+// %<, %>, %=
+type lines byte
+
+// %l, %L
+type text byte
+
+// %[spec]V
+type number struct {
+	spec string
+	v    byte
+}
+
+// %(A=B?T:E)
+type cond struct {
+	a    operand
+	b    operand
+	then []node
+	els  []node
+}
+
+type operand struct {
+	v   byte
+	num int
+}
+
+type parser struct {
+	s     string
+	pos   int
+	group bool
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid format %q: %s", p.s, fmt.Sprintf(format, args...))
+}
+
+// Parse until end of string or one of the terminator characters.
+func (p *parser) parse(term string) ([]node, error) {
+	nodes := []node{}
+	lit := []byte{}
+	for p.pos < len(p.s) && strings.IndexByte(term, p.s[p.pos]) == -1 {
+		if p.s[p.pos] != '%' {
+			lit = append(lit, p.s[p.pos])
+			p.pos++
+			continue
+		}
+		p.pos++
+		if p.pos >= len(p.s) {
+			return nil, p.errorf("trailing '%%'")
+		}
+		ch := p.s[p.pos]
+		if ch == '%' {
+			lit = append(lit, '%')
+			p.pos++
+			continue
+		}
+		if ch == 'c' {
+			c, err := p.parsechar()
+			if err != nil {
+				return nil, err
+			}
+			lit = append(lit, c)
+			continue
+		}
+		if len(lit) != 0 {
+			nodes = append(nodes, literal(lit))
+			lit = []byte{}
+		}
+		n, err := p.parsedirective()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	if len(lit) != 0 {
+		nodes = append(nodes, literal(lit))
+	}
+	return nodes, nil
+}
+
+// %c'C' or %c'\OOO'
+func (p *parser) parsechar() (byte, error) {
+	p.pos++
+	if p.pos >= len(p.s) || p.s[p.pos] != '\'' {
+		return 0, p.errorf("%%c must be followed by a quoted character")
+	}
+	p.pos++
+	var c byte
+	if p.pos < len(p.s) && p.s[p.pos] == '\\' {
+		p.pos++
+		n := 0
+		v := 0
+		for ; n < 3 && p.pos < len(p.s) && '0' <= p.s[p.pos] && p.s[p.pos] <= '7'; n++ {
+			v = v*8 + int(p.s[p.pos]-'0')
+			p.pos++
+		}
+		if n == 0 || v > 0377 {
+			return 0, p.errorf("invalid octal escape in %%c")
+		}
+		c = byte(v)
+	} else if p.pos < len(p.s) && p.s[p.pos] != '\'' {
+		c = p.s[p.pos]
+		p.pos++
+	} else {
+		return 0, p.errorf("empty %%c''")
+	}
+	if p.pos >= len(p.s) || p.s[p.pos] != '\'' {
+		return 0, p.errorf("unterminated %%c'")
+	}
+	p.pos++
+	return c, nil
+}
+
+func (p *parser) parsedirective() (node, error) {
+	ch := p.s[p.pos]
+	if p.group && (ch == '<' || ch == '>' || ch == '=') {
+		p.pos++
+		return lines(ch), nil
+	}
+	if !p.group && (ch == 'l' || ch == 'L') {
+		p.pos++
+		return text(ch), nil
+	}
+	if ch == '(' {
+		p.pos++
+		return p.parsecond()
+	}
+	start := p.pos
+	for p.pos < len(p.s) && strings.IndexByte("-'0 +#", p.s[p.pos]) != -1 {
+		p.pos++
+	}
+	for p.pos < len(p.s) && '0' <= p.s[p.pos] && p.s[p.pos] <= '9' {
+		p.pos++
+	}
+	if p.pos < len(p.s) && p.s[p.pos] == '.' {
+		p.pos++
+		for p.pos < len(p.s) && '0' <= p.s[p.pos] && p.s[p.pos] <= '9' {
+			p.pos++
+		}
+	}
+	if p.pos+1 >= len(p.s) || strings.IndexByte("doxX", p.s[p.pos]) == -1 {
+		return nil, p.errorf("unknown directive at '%%%s'", p.s[start:])
+	}
+	// The ' flag (thousands grouping) is accepted but has no effect.
+	spec := "%" + strings.Replace(p.s[start:p.pos+1], "'", "", -1)
+	p.pos++
+	v := p.s[p.pos]
+	if !p.isvar(v) {
+		return nil, p.errorf("unknown line number variable '%c'", v)
+	}
+	p.pos++
+	return number{spec: spec, v: v}, nil
+}
+
+func (p *parser) parsecond() (node, error) {
+	a, err := p.parseoperand()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos >= len(p.s) || p.s[p.pos] != '=' {
+		return nil, p.errorf("'=' expected in %%(")
+	}
+	p.pos++
+	b, err := p.parseoperand()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos >= len(p.s) || p.s[p.pos] != '?' {
+		return nil, p.errorf("'?' expected in %%(")
+	}
+	p.pos++
+	then, err := p.parse(":")
+	if err != nil {
+		return nil, err
+	}
+	if p.pos >= len(p.s) {
+		return nil, p.errorf("':' expected in %%(")
+	}
+	p.pos++
+	els, err := p.parse(")")
+	if err != nil {
+		return nil, err
+	}
+	if p.pos >= len(p.s) {
+		return nil, p.errorf("')' expected in %%(")
+	}
+	p.pos++
+	return cond{a: a, b: b, then: then, els: els}, nil
+}
+
+func (p *parser) parseoperand() (operand, error) {
+	if p.pos < len(p.s) && p.isvar(p.s[p.pos]) {
+		p.pos++
+		return operand{v: p.s[p.pos-1]}, nil
+	}
+	start := p.pos
+	num := 0
+	for p.pos < len(p.s) && '0' <= p.s[p.pos] && p.s[p.pos] <= '9' {
+		num = num*10 + int(p.s[p.pos]-'0')
+		p.pos++
+	}
+	if start == p.pos {
+		return operand{}, p.errorf("number or variable expected in %%(")
+	}
+	return operand{num: num}, nil
+}
+
+func (p *parser) isvar(v byte) bool {
+	if p.group {
+		return strings.IndexByte("eflmnEFLMN", v) != -1
+	}
+	return v == 'n'
+}
+
+type executor struct {
+	w  *bufio.Writer
+	f  *compiled
+	al []string
+	bl []string
+}
+
+// Apply a group format to lines [astart, aend) of al and [bstart, bend) of bl.
+func (x *executor) group(nodes []node, astart int, aend int, bstart int, bend int) {
+	vars := map[byte]int{
+		'e': astart, 'f': astart + 1, 'l': aend, 'm': aend + 1, 'n': aend - astart,
+		'E': bstart, 'F': bstart + 1, 'L': bend, 'M': bend + 1, 'N': bend - bstart,
+	}
+	x.exec(nodes, vars, func(kind byte) {
+		switch kind {
+		case '<':
+			for a := astart; a < aend; a++ {
+				x.line(x.f.oldline, x.al[a], a+1)
+			}
+		case '>':
+			for b := bstart; b < bend; b++ {
+				x.line(x.f.newline, x.bl[b], b+1)
+			}
+		case '=':
+			for a := astart; a < aend; a++ {
+				x.line(x.f.unchangedline, x.al[a], a+1)
+			}
+		}
+	}, "")
+}
+
+func (x *executor) line(nodes []node, line string, n int) {
+	x.exec(nodes, map[byte]int{'n': n}, nil, line)
+}
+
+func (x *executor) exec(nodes []node, vars map[byte]int, grouplines func(byte), line string) {
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case literal:
+			x.w.WriteString(string(n))
+		case lines:
+			grouplines(byte(n))
+		case text:
+			if n == 'l' {
+				x.w.WriteString(strings.TrimSuffix(line, "\n"))
+			} else {
+				x.w.WriteString(line)
+			}
+		case number:
+			fmt.Fprintf(x.w, n.spec, vars[n.v])
+		case cond:
+			if n.a.value(vars) == n.b.value(vars) {
+				x.exec(n.then, vars, grouplines, line)
+			} else {
+				x.exec(n.els, vars, grouplines, line)
+			}
+		}
+	}
+}
+
+func (o operand) value(vars map[byte]int) int {
+	if o.v != 0 {
+		return vars[o.v]
+	}
+	return o.num
+}
@@ -0,0 +1,114 @@
+package lineformat
+
+import (
+	"bytes"
+	"github.com/hattya/go.diff"
+	"testing"
+)
+
+var al = []string{"a\n", "b\n", "c\n"}
+var bl = []string{"a\n", "x\n", "c\n", "d\n"}
+var cl = []diff.Change{{A: 1, B: 1, Del: 1, Ins: 1}, {A: 3, B: 3, Del: 0, Ins: 1}}
+
+func write(t *testing.T, f Formats) string {
+	var buf bytes.Buffer
+	if err := Write(&buf, cl, al, bl, f); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestDefault(t *testing.T) {
+	if s := write(t, Default()); s != "a\nb\nx\nc\nd\n" {
+		t.Errorf("RESULT: %q", s)
+	}
+}
+
+func TestIfdef(t *testing.T) {
+	expected := "a\n#ifndef X\nb\n#else /* X */\nx\n#endif /* X */\nc\n#ifdef X\nd\n#endif /* X */\n"
+	if s := write(t, Ifdef("X")); s != expected {
+		t.Errorf("RESULT: %q EXPECTED: %q", s, expected)
+	}
+}
+
+// Expected results are the output of GNU diff with the same formats.
+func TestGroupFormats(t *testing.T) {
+	f := Default()
+	f.ChangedGroup = "%df,%dl c %dF,%dL%c'\\012'%<---%c'\\012'%>"
+	f.NewGroup = "%de a %dF,%dL %(N=1?one:many)%c'\\012'%>"
+	f.UnchangedGroup = ""
+	f.OldLine = "< %l\n"
+	f.NewLine = "> %L"
+	expected := "2,2 c 2,2\n< b\n---\n> x\n3 a 4,4 one\n> d\n"
+	if s := write(t, f); s != expected {
+		t.Errorf("RESULT: %q EXPECTED: %q", s, expected)
+	}
+}
+
+func TestLineFormats(t *testing.T) {
+	f := Default()
+	f.OldLine = "%3dn%c':'%%%L"
+	f.NewLine = f.OldLine
+	f.UnchangedLine = f.OldLine
+	expected := "  1:%a\n  2:%b\n  2:%x\n  3:%c\n  4:%d\n"
+	if s := write(t, f); s != expected {
+		t.Errorf("RESULT: %q EXPECTED: %q", s, expected)
+	}
+	f.UnchangedLine = "%(n=1?first:%-3xn|)%l\n"
+	f.OldLine = "-"
+	f.NewLine = "+"
+	if s := write(t, f); s != "firsta\n-+3  |c\n+" {
+		t.Errorf("RESULT: %q", s)
+	}
+}
+
+func TestWriteLines(t *testing.T) {
+	al := []string{"1\n", "2\n", "3\n", "4\n", "5\n"}
+	bl := []string{"1\n", "2\n", "three\n", "4\n", "5\n"}
+	cl := []diff.Change{{A: 2, B: 2, Del: 1, Ins: 1}}
+	f := Default()
+	f.UnchangedLine = "%dn %L"
+	f.OldLine = "%dn-%L"
+	f.NewLine = "%dn+%L"
+	var buf bytes.Buffer
+	if err := WriteLines(&buf, cl, al, 1, 4, bl, 1, 4, f); err != nil {
+		t.Fatal(err)
+	}
+	expected := "2 2\n3-3\n3+three\n4 4\n"
+	if buf.String() != expected {
+		t.Errorf("RESULT: %q EXPECTED: %q", buf.String(), expected)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, format := range []string{
+		"%",
+		"%q",
+		"%dq",
+		"%5",
+		"%c",
+		"%c'",
+		"%c''",
+		"%c'ab'",
+		"%c'\\9'",
+		"%(",
+		"%(1",
+		"%(1=2",
+		"%(1=2?x",
+		"%(1=2?x:y",
+		"%(q=1?x:y)",
+	} {
+		f := Default()
+		f.ChangedGroup = format
+		if err := Write(&bytes.Buffer{}, cl, al, bl, f); err == nil {
+			t.Errorf("%q: error expected", format)
+		}
+	}
+	for _, format := range []string{"%<", "%de", "%(e=1?x:y)"} {
+		f := Default()
+		f.OldLine = format
+		if err := Write(&bytes.Buffer{}, cl, al, bl, f); err == nil {
+			t.Errorf("%q: error expected in a line format", format)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+// Directory manifests
+//
+// A manifest is a snapshot of a tree: the path, type, mode, size,
+// modification time and SHA-256 digest of every file and directory, and
+// optionally the content of text files.  It is written as JSON lines, a header
+// line followed by one entry per line:
+//
+//   {"diff-manifest":1}
+//   {"path":"dir","type":"dir","mode":"0755","size":4096,"mtime":"..."}
+//   {"path":"dir/file","type":"file","mode":"0644","size":6,"mtime":"...","sha256":"...","content":"hello\n"}
+//   {"path":"dir/link","type":"symlink","mode":"0777","size":7,"mtime":"...","target":"missing"}
+//
+// Paths are slash separated and relative to the root of the tree, parents
+// before children.  The type is "file", "dir" or "symlink", and the mode is
+// the octal permission bits as in chmod.  Symbolic links are followed as when
+// directories are compared, so only links that are not followed are stored as
+// links.  A manifest can be opened as an fs.FS; reading a file whose content
+// is not stored fails with ErrNoContent.
+
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"diff/dirwalk"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const Header = `{"diff-manifest":1}`
+
+var ErrNoContent = errors.New("content is not stored in the manifest")
+
+type Entry struct {
+	Path string `json:"path"`
+	// "file", "dir" or "symlink".
+	Type string `json:"type"`
+	// Octal, with setuid 4000, setgid 2000 and sticky 1000.
+	Mode    string    `json:"mode"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	// Hex encoded, regular files only.
+	SHA256 string `json:"sha256,omitempty"`
+	// Symbolic links only.
+	Target string `json:"target,omitempty"`
+	// Nil when the content is not stored.
+	Content *string `json:"content,omitempty"`
+}
+
+type Options struct {
+	// Store the content of text files.  A file is text when it is valid
+	// UTF-8 without NUL bytes.
+	Content bool
+	// Store symbolic links as links instead of following them.
+	NoDereference bool
+}
+
+// Create walks fsys and returns the entries of its files, directories and
+// symbolic links.  Other file types are skipped.  The target of a link is
+// stored when fsys has a ReadLink method.  A directory that is one of its own
+// ancestors is stored without its entries.  The tree is walked with dirwalk as
+// a pair of itself, so links are followed as in a comparison.
+func Create(fsys fs.FS, opts Options) ([]Entry, error) {
+	entries := []Entry{}
+	wopts := dirwalk.Options{NoDereference: opts.NoDereference}
+	err := dirwalk.Walk(fsys, ".", fsys, ".", wopts, func(dir string, _ string, de dirwalk.Entry) error {
+		fi := de.A
+		name := path.Join(dir, fi.Name())
+		e := Entry{Path: name, Mode: unixmode(fi.Mode()), Size: fi.Size(), ModTime: fi.ModTime()}
+		switch {
+		case fi.IsDir():
+			e.Type = "dir"
+		case fi.Mode()&fs.ModeSymlink != 0:
+			e.Type = "symlink"
+			if lfs, ok := fsys.(interface {
+				ReadLink(name string) (string, error)
+			}); ok {
+				target, err := lfs.ReadLink(name)
+				if err != nil {
+					return err
+				}
+				e.Target = target
+			}
+		case fi.Mode().IsRegular():
+			e.Type = "file"
+			data, err := fs.ReadFile(fsys, name)
+			if err != nil {
+				return err
+			}
+			sum := sha256.Sum256(data)
+			e.SHA256 = hex.EncodeToString(sum[:])
+			if opts.Content && istext(data) {
+				s := string(data)
+				e.Content = &s
+			}
+		default:
+			return nil
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// The mode bits of m as in chmod.
+func unixmode(m fs.FileMode) string {
+	u := uint32(m.Perm())
+	if m&fs.ModeSetuid != 0 {
+		u |= 04000
+	}
+	if m&fs.ModeSetgid != 0 {
+		u |= 02000
+	}
+	if m&fs.ModeSticky != 0 {
+		u |= 01000
+	}
+	return fmt.Sprintf("%04o", u)
+}
+
+// FileMode returns the type and mode bits of e.
+func (e *Entry) FileMode() fs.FileMode {
+	u, _ := strconv.ParseUint(e.Mode, 8, 32)
+	m := fs.FileMode(u) & fs.ModePerm
+	if u&04000 != 0 {
+		m |= fs.ModeSetuid
+	}
+	if u&02000 != 0 {
+		m |= fs.ModeSetgid
+	}
+	if u&01000 != 0 {
+		m |= fs.ModeSticky
+	}
+	switch e.Type {
+	case "dir":
+		m |= fs.ModeDir
+	case "symlink":
+		m |= fs.ModeSymlink
+	}
+	return m
+}
+
+func istext(data []byte) bool {
+	return bytes.IndexByte(data, 0) == -1 && utf8.Valid(data)
+}
+
+func Write(w io.Writer, entries []Entry) error {
+	if _, err := fmt.Fprintln(w, Header); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Read(r io.Reader) ([]Entry, error) {
+	br := bufio.NewReader(r)
+	line, err := br.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if strings.TrimSuffix(line, "\n") != Header {
+		return nil, errors.New("not a manifest")
+	}
+	entries := []Entry{}
+	dec := json.NewDecoder(br)
+	for n := 2; ; n++ {
+		var e Entry
+		err := dec.Decode(&e)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("manifest entry %d: %s", n, err)
+		}
+		if !fs.ValidPath(e.Path) || e.Path == "." {
+			return nil, fmt.Errorf("manifest entry %d: invalid path %q", n, e.Path)
+		} else if e.Type != "file" && e.Type != "dir" && e.Type != "symlink" {
+			return nil, fmt.Errorf("manifest entry %d: invalid type %q", n, e.Type)
+		} else if u, err := strconv.ParseUint(e.Mode, 8, 32); err != nil || u > 07777 {
+			return nil, fmt.Errorf("manifest entry %d: invalid mode %q", n, e.Mode)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// IsManifest reports whether data begins with the manifest header.
+func IsManifest(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(Header+"\n")) || string(data) == Header
+}
+
+// FS is a manifest opened as a file system.
+type FS struct {
+	entries  map[string]*Entry
+	children map[string][]string
+}
+
+// New returns the file system of entries.  Missing parent directories are
+// added.
+func New(entries []Entry) *FS {
+	m := &FS{entries: map[string]*Entry{}, children: map[string][]string{}}
+	m.entries["."] = &Entry{Path: ".", Type: "dir", Mode: "0755"}
+	for i := range entries {
+		m.add(&entries[i])
+	}
+	for _, names := range m.children {
+		sort.Strings(names)
+	}
+	return m
+}
+
+func (m *FS) add(e *Entry) {
+	if _, ok := m.entries[e.Path]; ok {
+		m.entries[e.Path] = e
+		return
+	}
+	dir := path.Dir(e.Path)
+	if _, ok := m.entries[dir]; !ok {
+		m.add(&Entry{Path: dir, Type: "dir", Mode: "0755"})
+	}
+	m.entries[e.Path] = e
+	m.children[dir] = append(m.children[dir], path.Base(e.Path))
+}
+
+func (m *FS) lookup(op string, name string) (*Entry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	e, ok := m.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return e, nil
+}
+
+func (m *FS) Open(name string) (fs.File, error) {
+	e, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f := &file{m: m, e: e}
+	if e.Content != nil {
+		f.r = strings.NewReader(*e.Content)
+	}
+	return f, nil
+}
+
+func (m *FS) Stat(name string) (fs.FileInfo, error) {
+	e, err := m.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return info{e}, nil
+}
+
+func (m *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	e, err := m.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if e.Type != "dir" {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	dl := []fs.DirEntry{}
+	for _, child := range m.children[name] {
+		dl = append(dl, fs.FileInfoToDirEntry(info{m.entries[path.Join(name, child)]}))
+	}
+	return dl, nil
+}
+
+// ReadLink returns the target of the symbolic link name.
+func (m *FS) ReadLink(name string) (string, error) {
+	e, err := m.lookup("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	if e.Type != "symlink" {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return e.Target, nil
+}
+
+// Digest returns the hex encoded SHA-256 digest of the regular file name.
+// The digest stored in a manifest is used without reading the file.
+func Digest(fsys fs.FS, name string) (string, error) {
+	if m, ok := fsys.(*FS); ok {
+		e, err := m.lookup("digest", name)
+		if err != nil {
+			return "", err
+		}
+		return e.SHA256, nil
+	}
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// HasContent reports whether the content of name can be read.  It is false
+// only for files of a manifest without stored content.
+func HasContent(fsys fs.FS, name string) bool {
+	m, ok := fsys.(*FS)
+	if !ok {
+		return true
+	}
+	e, err := m.lookup("open", name)
+	return err != nil || e.Type != "file" || e.Content != nil
+}
+
+type info struct {
+	e *Entry
+}
+
+func (fi info) Name() string       { return path.Base(fi.e.Path) }
+func (fi info) Size() int64        { return fi.e.Size }
+func (fi info) Mode() fs.FileMode  { return fi.e.FileMode() }
+func (fi info) ModTime() time.Time { return fi.e.ModTime }
+func (fi info) IsDir() bool        { return fi.e.Type == "dir" }
+func (fi info) Sys() interface{}   { return fi.e }
+
+type file struct {
+	m *FS
+	e *Entry
+	// Nil when the content is not stored.
+	r *strings.Reader
+	// Offset of ReadDir.
+	off int
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return info{f.e}, nil
+}
+
+func (f *file) Read(b []byte) (int, error) {
+	if f.e.Type == "dir" {
+		return 0, &fs.PathError{Op: "read", Path: f.e.Path, Err: errors.New("is a directory")}
+	} else if f.r == nil {
+		return 0, &fs.PathError{Op: "read", Path: f.e.Path, Err: ErrNoContent}
+	}
+	return f.r.Read(b)
+}
+
+func (f *file) ReadDir(n int) ([]fs.DirEntry, error) {
+	dl, err := f.m.ReadDir(f.e.Path)
+	if err != nil {
+		return nil, err
+	}
+	dl = dl[f.off:]
+	if n > 0 && len(dl) > n {
+		dl = dl[:n]
+	}
+	f.off += len(dl)
+	if n > 0 && len(dl) == 0 {
+		return nil, io.EOF
+	}
+	return dl, nil
+}
+
+func (f *file) Close() error {
+	return nil
+}
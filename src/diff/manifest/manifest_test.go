@@ -0,0 +1,157 @@
+package manifest
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	mtime := time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)
+	src := fstest.MapFS{
+		"a.txt":     {Data: []byte("a\n"), Mode: 0644, ModTime: mtime},
+		"bin":       {Data: []byte("\x00\x01"), Mode: 0755, ModTime: mtime},
+		"dir/b.txt": {Data: []byte("b\n"), Mode: 0600, ModTime: mtime},
+		"empty":     {Mode: fs.ModeDir | 0700, ModTime: mtime},
+	}
+	entries, err := Create(src, Options{Content: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	if !IsManifest(buf.Bytes()) {
+		t.Fatalf("header expected: %q", buf.String())
+	}
+	read, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, entries) {
+		t.Errorf("RESULT:\n%+v\nEXPECTED:\n%+v", read, entries)
+	}
+
+	// TestFS reads every file, so leave out the one without content.
+	text := []Entry{}
+	for _, e := range read {
+		if e.Path != "bin" {
+			text = append(text, e)
+		}
+	}
+	if err := fstest.TestFS(New(text), "a.txt", "dir/b.txt", "empty"); err != nil {
+		t.Error(err)
+	}
+	m := New(read)
+	data, err := fs.ReadFile(m, "dir/b.txt")
+	if err != nil || string(data) != "b\n" {
+		t.Errorf("content: %q, %v", data, err)
+	}
+	if _, err := fs.ReadFile(m, "bin"); !errors.Is(err, ErrNoContent) {
+		t.Errorf("binary content must not be stored: %v", err)
+	}
+	if HasContent(m, "bin") || !HasContent(m, "a.txt") || !HasContent(src, "bin") {
+		t.Error("HasContent mismatch")
+	}
+	for _, name := range []string{"a.txt", "bin"} {
+		md, err := Digest(m, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sd, err := Digest(src, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if md != sd {
+			t.Errorf("%s: digest mismatch %s %s", name, md, sd)
+		}
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "dir"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "dir", "f"), []byte("f\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	for name, target := range map[string]string{"ldir": "dir", "lf": "dir/f", "dangling": "none", "dir/loop": "."} {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		opts     Options
+		expected []string
+	}{
+		{
+			Options{},
+			[]string{"dangling symlink 0777 none", "dir dir 0750 ", "dir/f file 0640 ", "dir/loop dir 0750 ", "ldir dir 0750 ", "ldir/f file 0640 ", "ldir/loop dir 0750 ", "lf file 0640 "},
+		},
+		{
+			Options{NoDereference: true},
+			[]string{"dangling symlink 0777 none", "dir dir 0750 ", "dir/f file 0640 ", "dir/loop symlink 0777 .", "ldir symlink 0777 dir", "lf symlink 0777 dir/f"},
+		},
+	}
+	for _, tt := range tests {
+		entries, err := Create(os.DirFS(dir), tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := []string{}
+		for _, e := range entries {
+			r = append(r, e.Path+" "+e.Type+" "+e.Mode+" "+e.Target)
+		}
+		if !reflect.DeepEqual(r, tt.expected) {
+			t.Errorf("%+v: RESULT: %q EXPECTED: %q", tt.opts, r, tt.expected)
+		}
+	}
+	m := New([]Entry{{Path: "l", Type: "symlink", Mode: "0777", Target: "x"}, {Path: "d", Type: "dir", Mode: "1777"}})
+	if target, err := m.ReadLink("l"); err != nil || target != "x" {
+		t.Errorf("ReadLink: %q, %v", target, err)
+	}
+	if _, err := m.ReadLink("d"); err == nil {
+		t.Error("ReadLink: error expected")
+	}
+	if fi, err := fs.Stat(m, "d"); err != nil {
+		t.Error(err)
+	} else if fi.Mode() != fs.ModeDir|fs.ModeSticky|0777 {
+		t.Errorf("Stat: %v", fi.Mode())
+	}
+}
+
+func TestNewParents(t *testing.T) {
+	m := New([]Entry{{Path: "a/b/c", Type: "file", Mode: "0644"}})
+	fi, err := fs.Stat(m, "a/b")
+	if err != nil || !fi.IsDir() {
+		t.Fatalf("parent directory expected: %v", err)
+	}
+	dl, err := fs.ReadDir(m, "a")
+	if err != nil || len(dl) != 1 || dl[0].Name() != "b" {
+		t.Errorf("ReadDir: %v, %v", dl, err)
+	}
+}
+
+func TestReadError(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"{}\n",
+		Header + "\n{\"path\":\"../a\"}\n",
+		Header + "\n{\"path\":1}\n",
+		Header + "\n{\"path\":\"a\",\"type\":\"fifo\",\"mode\":\"0644\"}\n",
+		Header + "\n{\"path\":\"a\",\"type\":\"file\",\"mode\":\"0x1\"}\n",
+		Header + "\n{\"path\":\"a\",\"type\":\"file\",\"mode\":420}\n",
+	} {
+		if _, err := Read(bytes.NewBufferString(s)); err == nil {
+			t.Errorf("%q: error expected", s)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+// Three-way merge
+//
+// Base is diffed against ours and against theirs.  Changes are grouped when
+// their base ranges overlap or touch.  A group changed on one side only takes
+// that side, a group changed identically on both sides takes either, and
+// any other group becomes a conflict:
+//
+//   <<<<<<< ours
+//   lines of ours
+//   ||||||| base          (diff3 and zdiff3 styles only)
+//   lines of base
+//   =======
+//   lines of theirs
+//   >>>>>>> theirs
+//
+// The merge and zdiff3 styles move lines common to the beginning or end of
+// both sides out of the conflict.
+
+package merge
+
+import (
+	"diff/histogramdiff"
+	"github.com/hattya/go.diff"
+	"strings"
+)
+
+const (
+	MarkerOurs   = "<<<<<<<"
+	MarkerBase   = "|||||||"
+	MarkerSep    = "======="
+	MarkerTheirs = ">>>>>>>"
+)
+
+type Style int
+
+const (
+	StyleMerge Style = iota
+	StyleDiff3
+	StyleZdiff3
+)
+
+type Options struct {
+	Style Style
+	// Diff algorithm, histogramdiff.Strings if nil.
+	Algorithm   func(a []string, b []string) []diff.Change
+	OursLabel   string
+	BaseLabel   string
+	TheirsLabel string
+}
+
+// Lines [Start, Start+Count) of a text, zero based.
+type Range struct {
+	Start int
+	Count int
+}
+
+type Conflict struct {
+	Base   Range
+	Ours   Range
+	Theirs Range
+	// The conflict in Result.Lines including the marker lines.
+	Output Range
+}
+
+type Result struct {
+	Lines     []string
+	Conflicts []Conflict
+}
+
+// A change of one side in base coordinates.
+type hunk struct {
+	astart int
+	aend   int
+	bstart int
+	bend   int
+}
+
+// Merge merges the changes from base to ours and from base to theirs.
+func Merge(base []string, ours []string, theirs []string, opts Options) Result {
+	algorithm := opts.Algorithm
+	if algorithm == nil {
+		algorithm = histogramdiff.Strings
+	}
+	oh := to_hunks(algorithm(base, ours))
+	th := to_hunks(algorithm(base, theirs))
+
+	r := Result{Lines: []string{}, Conflicts: []Conflict{}}
+	a := 0
+	i := 0
+	j := 0
+	for i < len(oh) || j < len(th) {
+		// Start a group with the first change and extend it while changes
+		// of either side overlap or touch it.
+		gs := 0
+		if j >= len(th) || (i < len(oh) && oh[i].astart <= th[j].astart) {
+			gs = oh[i].astart
+		} else {
+			gs = th[j].astart
+		}
+		ge := gs
+		ostart, oend := i, i
+		tstart, tend := j, j
+		for {
+			if oend < len(oh) && oh[oend].astart <= ge {
+				if oh[oend].aend > ge {
+					ge = oh[oend].aend
+				}
+				oend++
+			} else if tend < len(th) && th[tend].astart <= ge {
+				if th[tend].aend > ge {
+					ge = th[tend].aend
+				}
+				tend++
+			} else {
+				break
+			}
+		}
+		i = oend
+		j = tend
+
+		r.Lines = append(r.Lines, base[a:gs]...)
+		a = ge
+		obs, obe := side_range(oh[ostart:oend], gs, ge)
+		tbs, tbe := side_range(th[tstart:tend], gs, ge)
+		if ostart == oend {
+			r.Lines = append(r.Lines, theirs[tbs:tbe]...)
+		} else if tstart == tend {
+			r.Lines = append(r.Lines, ours[obs:obe]...)
+		} else if equal(ours[obs:obe], theirs[tbs:tbe]) {
+			r.Lines = append(r.Lines, ours[obs:obe]...)
+		} else {
+			r.conflict(base, gs, ge, ours, obs, obe, theirs, tbs, tbe, opts)
+		}
+	}
+	r.Lines = append(r.Lines, base[a:]...)
+	return r
+}
+
+func to_hunks(cl []diff.Change) []hunk {
+	hl := []hunk{}
+	for _, c := range cl {
+		hl = append(hl, hunk{c.A, c.A + c.Del, c.B, c.B + c.Ins})
+	}
+	return hl
+}
+
+// Range of the side corresponding to base range [gs, ge) given the changes
+// of that side within the range.
+func side_range(hl []hunk, gs int, ge int) (int, int) {
+	if len(hl) == 0 {
+		return 0, 0
+	}
+	first := hl[0]
+	last := hl[len(hl)-1]
+	return first.bstart - (first.astart - gs), last.bend + (ge - last.aend)
+}
+
+func equal(al []string, bl []string) bool {
+	if len(al) != len(bl) {
+		return false
+	}
+	for i := range al {
+		if al[i] != bl[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Result) conflict(base []string, bs int, be int, ours []string, ob int, oe int, theirs []string, tb int, te int, opts Options) {
+	prefix := 0
+	suffix := 0
+	if opts.Style != StyleDiff3 {
+		for ob+prefix < oe && tb+prefix < te && ours[ob+prefix] == theirs[tb+prefix] {
+			prefix++
+		}
+		for ob+prefix < oe-suffix && tb+prefix < te-suffix && ours[oe-suffix-1] == theirs[te-suffix-1] {
+			suffix++
+		}
+	}
+	r.Lines = append(r.Lines, ours[ob:ob+prefix]...)
+	ob += prefix
+	tb += prefix
+	oe -= suffix
+	te -= suffix
+	c := Conflict{
+		Base:   Range{bs, be - bs},
+		Ours:   Range{ob, oe - ob},
+		Theirs: Range{tb, te - tb},
+	}
+	c.Output.Start = len(r.Lines)
+	r.marker(MarkerOurs, opts.OursLabel)
+	r.text(ours[ob:oe])
+	if opts.Style != StyleMerge {
+		r.marker(MarkerBase, opts.BaseLabel)
+		r.text(base[bs:be])
+	}
+	r.marker(MarkerSep, "")
+	r.text(theirs[tb:te])
+	r.marker(MarkerTheirs, opts.TheirsLabel)
+	c.Output.Count = len(r.Lines) - c.Output.Start
+	r.Conflicts = append(r.Conflicts, c)
+	r.Lines = append(r.Lines, ours[oe:oe+suffix]...)
+}
+
+func (r *Result) marker(marker string, label string) {
+	if label != "" {
+		marker += " " + label
+	}
+	r.Lines = append(r.Lines, marker+"\n")
+}
+
+// Lines inside a conflict are followed by a marker, so they must end with a
+// newline.
+func (r *Result) text(lines []string) {
+	for _, line := range lines {
+		if !strings.HasSuffix(line, "\n") {
+			line += "\n"
+		}
+		r.Lines = append(r.Lines, line)
+	}
+}
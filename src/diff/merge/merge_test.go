@@ -0,0 +1,41 @@
+package merge
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConflicts(t *testing.T) {
+	base := []string{"a\n", "b\n", "c\n", "d\n", "e\n"}
+	ours := []string{"a\n", "B\n", "c\n", "x\n", "y\n", "e\n"}
+	theirs := []string{"a\n", "b\n", "c\n", "x\n", "z\n", "e\n", "f\n"}
+	for _, tc := range []struct {
+		style    Style
+		lines    []string
+		conflict Conflict
+	}{
+		{
+			StyleMerge,
+			[]string{"a\n", "B\n", "c\n", "x\n", "<<<<<<< ours\n", "y\n", "=======\n", "z\n", ">>>>>>> theirs\n", "e\n", "f\n"},
+			Conflict{Base: Range{3, 1}, Ours: Range{4, 1}, Theirs: Range{4, 1}, Output: Range{4, 5}},
+		},
+		{
+			StyleDiff3,
+			[]string{"a\n", "B\n", "c\n", "<<<<<<< ours\n", "x\n", "y\n", "||||||| base\n", "d\n", "=======\n", "x\n", "z\n", ">>>>>>> theirs\n", "e\n", "f\n"},
+			Conflict{Base: Range{3, 1}, Ours: Range{3, 2}, Theirs: Range{3, 2}, Output: Range{3, 9}},
+		},
+		{
+			StyleZdiff3,
+			[]string{"a\n", "B\n", "c\n", "x\n", "<<<<<<< ours\n", "y\n", "||||||| base\n", "d\n", "=======\n", "z\n", ">>>>>>> theirs\n", "e\n", "f\n"},
+			Conflict{Base: Range{3, 1}, Ours: Range{4, 1}, Theirs: Range{4, 1}, Output: Range{4, 7}},
+		},
+	} {
+		r := Merge(base, ours, theirs, Options{Style: tc.style, OursLabel: "ours", BaseLabel: "base", TheirsLabel: "theirs"})
+		if !reflect.DeepEqual(r.Lines, tc.lines) {
+			t.Errorf("style %d: lines mismatch:\nRESULT:\n%q\nEXPECTED:\n%q", tc.style, r.Lines, tc.lines)
+		}
+		if !reflect.DeepEqual(r.Conflicts, []Conflict{tc.conflict}) {
+			t.Errorf("style %d: conflicts mismatch:\nRESULT:\n%+v\nEXPECTED:\n%+v", tc.style, r.Conflicts, tc.conflict)
+		}
+	}
+}
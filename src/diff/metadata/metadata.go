@@ -0,0 +1,130 @@
+// File metadata comparison
+//
+// Compares the metadata of two files that content comparison ignores: the
+// permission bits, the owner, the modification time and the extended
+// attributes.  The owner is only available on Unix and extended attributes
+// only on Linux; elsewhere they compare equal.
+
+package metadata
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"time"
+)
+
+type Attr struct {
+	Mode     fs.FileMode
+	HasOwner bool
+	Uid      int
+	Gid      int
+	ModTime  time.Time
+	// Nil when extended attributes were not read.
+	Xattrs map[string][]byte
+}
+
+type Options struct {
+	Mode  bool
+	Owner bool
+	Mtime bool
+	Xattr bool
+	// Modification times closer than this compare equal.
+	MtimeTolerance time.Duration
+}
+
+// A differing field with its values formatted for printing.  Field is
+// "mode", "uid", "gid", "mtime" or "xattr:" followed by the name of the
+// attribute.
+type Difference struct {
+	Field string
+	A     string
+	B     string
+}
+
+const mode_bits = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+
+// Stat returns the metadata of the file at path, following symbolic links.
+// Extended attributes are read only when xattr is set.
+func Stat(path string, xattr bool) (Attr, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return Attr{}, err
+	}
+	a := Info(fi)
+	if xattr {
+		a.Xattrs, err = xattrs(path)
+		if err != nil {
+			return Attr{}, err
+		}
+	}
+	return a, nil
+}
+
+// Info returns the metadata in fi, without extended attributes.
+func Info(fi fs.FileInfo) Attr {
+	a := Attr{Mode: fi.Mode() & mode_bits, ModTime: fi.ModTime()}
+	a.Uid, a.Gid, a.HasOwner = owner(fi)
+	return a
+}
+
+// Compare returns the differences between a and b selected by opts.
+func Compare(a Attr, b Attr, opts Options) []Difference {
+	dl := []Difference{}
+	if opts.Mode && a.Mode != b.Mode {
+		dl = append(dl, Difference{"mode", a.Mode.String(), b.Mode.String()})
+	}
+	if opts.Owner && a.HasOwner && b.HasOwner {
+		if a.Uid != b.Uid {
+			dl = append(dl, Difference{"uid", fmt.Sprint(a.Uid), fmt.Sprint(b.Uid)})
+		}
+		if a.Gid != b.Gid {
+			dl = append(dl, Difference{"gid", fmt.Sprint(a.Gid), fmt.Sprint(b.Gid)})
+		}
+	}
+	if opts.Mtime {
+		d := a.ModTime.Sub(b.ModTime)
+		if d < 0 {
+			d = -d
+		}
+		if d > opts.MtimeTolerance {
+			dl = append(dl, Difference{"mtime", format_time(a.ModTime), format_time(b.ModTime)})
+		}
+	}
+	if opts.Xattr && a.Xattrs != nil && b.Xattrs != nil {
+		for _, name := range xattr_names(a.Xattrs, b.Xattrs) {
+			av, aok := a.Xattrs[name]
+			bv, bok := b.Xattrs[name]
+			if aok != bok || string(av) != string(bv) {
+				dl = append(dl, Difference{"xattr:" + name, format_xattr(av, aok), format_xattr(bv, bok)})
+			}
+		}
+	}
+	return dl
+}
+
+func format_time(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05.000000000 -0700")
+}
+
+func format_xattr(v []byte, ok bool) string {
+	if !ok {
+		return "(none)"
+	}
+	return fmt.Sprintf("%q", v)
+}
+
+func xattr_names(am map[string][]byte, bm map[string][]byte) []string {
+	names := []string{}
+	for name := range am {
+		names = append(names, name)
+	}
+	for name := range bm {
+		if _, ok := am[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,63 @@
+package metadata
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompare(t *testing.T) {
+	mtime := time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)
+	a := Attr{Mode: 0644, HasOwner: true, Uid: 1000, Gid: 100, ModTime: mtime, Xattrs: map[string][]byte{"user.a": []byte("1"), "user.b": []byte("2")}}
+	b := Attr{Mode: 0755, HasOwner: true, Uid: 1000, Gid: 0, ModTime: mtime.Add(1500 * time.Millisecond), Xattrs: map[string][]byte{"user.b": []byte("3"), "user.c": {}}}
+	opts := Options{Mode: true, Owner: true, Mtime: true, Xattr: true}
+	expected := []Difference{
+		{"mode", "-rw-r--r--", "-rwxr-xr-x"},
+		{"gid", "100", "0"},
+		{"mtime", "2015-01-02 03:04:05.000000000 +0000", "2015-01-02 03:04:06.500000000 +0000"},
+		{"xattr:user.a", `"1"`, "(none)"},
+		{"xattr:user.b", `"2"`, `"3"`},
+		{"xattr:user.c", "(none)", `""`},
+	}
+	dl := Compare(a, b, opts)
+	if !reflect.DeepEqual(dl, expected) {
+		t.Errorf("RESULT:\n%q\nEXPECTED:\n%q", dl, expected)
+	}
+
+	opts.MtimeTolerance = 2 * time.Second
+	dl = Compare(a, b, opts)
+	if len(dl) != 5 || dl[2].Field != "xattr:user.a" {
+		t.Errorf("mtime within tolerance: %q", dl)
+	}
+	if dl := Compare(a, b, Options{Mtime: true, MtimeTolerance: time.Second}); len(dl) != 1 {
+		t.Errorf("mtime out of tolerance: %q", dl)
+	}
+	if dl := Compare(a, b, Options{}); len(dl) != 0 {
+		t.Errorf("nothing selected: %q", dl)
+	}
+	b.HasOwner = false
+	if dl := Compare(a, b, Options{Owner: true}); len(dl) != 0 {
+		t.Errorf("unknown owner: %q", dl)
+	}
+}
+
+func TestStat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(path, []byte("x\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	a, err := Stat(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Mode != fs.FileMode(0600) || !a.ModTime.Equal(mtime) || a.Xattrs == nil {
+		t.Errorf("unexpected attributes: %+v", a)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package metadata
+
+import (
+	"os"
+)
+
+func owner(fi os.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,37 @@
+package metadata
+
+import (
+	"bytes"
+	"syscall"
+)
+
+func xattrs(path string) (map[string][]byte, error) {
+	m := map[string][]byte{}
+	size, err := syscall.Listxattr(path, nil)
+	if err == syscall.ENOTSUP {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	size, err = syscall.Listxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		vsize, err := syscall.Getxattr(path, string(name), nil)
+		if err != nil {
+			return nil, err
+		}
+		v := make([]byte, vsize)
+		vsize, err = syscall.Getxattr(path, string(name), v)
+		if err != nil {
+			return nil, err
+		}
+		m[string(name)] = v[:vsize]
+	}
+	return m, nil
+}
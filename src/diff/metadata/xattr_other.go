@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package metadata
+
+func xattrs(path string) (map[string][]byte, error) {
+	return map[string][]byte{}, nil
+}
@@ -0,0 +1,109 @@
+// Line normalizers
+//
+// A normalizer rewrites volatile parts of lines before they are compared.
+// It is either a substitution in the style of sed:
+//
+//   s/RE/REPLACEMENT/FLAGS
+//
+// where any character can take the place of /, every match is replaced, the
+// replacement can refer to submatches as $1 or ${name}, and FLAGS is empty
+// or "i" for case insensitive matching; or the name of a preset:
+//
+//   timestamp  dates and times such as 2006-01-02T15:04:05.000Z and 15:04:05
+//   uuid       UUIDs
+//   address    hexadecimal addresses such as 0xc000012345
+//   tmppath    paths under /tmp and /var/tmp
+
+package normalize
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type Rule struct {
+	re   *regexp.Regexp
+	repl string
+	expr string
+}
+
+var Presets = map[string]string{
+	"timestamp": `s/\d{4}-\d{2}-\d{2}([T ]\d{2}:\d{2}(:\d{2}([.,]\d+)?)?(Z|[+-]\d{2}:?\d{2})?)?|\b\d{2}:\d{2}:\d{2}([.,]\d+)?\b/<TIMESTAMP>/`,
+	"uuid":      `s/\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b/<UUID>/i`,
+	"address":   `s/\b0x[0-9a-fA-F]+\b/<ADDRESS>/`,
+	"tmppath":   `s#(/var)?/tmp/[^\s'":]*#<TMPPATH>#`,
+}
+
+// Parse parses a substitution or the name of a preset.
+func Parse(expr string) (*Rule, error) {
+	if p, ok := Presets[expr]; ok {
+		r, err := parse(p)
+		if err != nil {
+			return nil, err
+		}
+		r.expr = expr
+		return r, nil
+	} else if !strings.HasPrefix(expr, "s") || len(expr) < 2 {
+		names := []string{}
+		for name := range Presets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("'%s' is neither s/RE/REPLACEMENT/ nor a preset (%s)", expr, strings.Join(names, ", "))
+	}
+	return parse(expr)
+}
+
+func parse(expr string) (*Rule, error) {
+	delim := expr[1:2]
+	fields := []string{}
+	var sb strings.Builder
+	rest := expr[2:]
+	for len(rest) != 0 && len(fields) < 2 {
+		if strings.HasPrefix(rest, `\`+delim) {
+			sb.WriteString(delim)
+			rest = rest[1+len(delim):]
+		} else if rest[0] == '\\' && len(rest) > 1 {
+			sb.WriteString(rest[:2])
+			rest = rest[2:]
+		} else if strings.HasPrefix(rest, delim) {
+			fields = append(fields, sb.String())
+			sb.Reset()
+			rest = rest[len(delim):]
+		} else {
+			sb.WriteByte(rest[0])
+			rest = rest[1:]
+		}
+	}
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("'%s': unterminated substitution", expr)
+	}
+	pattern := fields[0]
+	switch rest {
+	case "":
+	case "i":
+		pattern = "(?i)" + pattern
+	default:
+		return nil, fmt.Errorf("'%s': unknown flags '%s'", expr, rest)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("'%s': %s", expr, err)
+	}
+	return &Rule{re: re, repl: fields[1], expr: expr}, nil
+}
+
+// Apply replaces every match of the rule in line.  The line ending is not
+// matched, so $ matches at the end of the text and the ending is kept.
+func (r *Rule) Apply(line string) string {
+	text := strings.TrimSuffix(line, "\n")
+	text = strings.TrimSuffix(text, "\r")
+	return r.re.ReplaceAllString(text, r.repl) + line[len(text):]
+}
+
+// String returns the expression the rule was parsed from.
+func (r *Rule) String() string {
+	return r.expr
+}
@@ -0,0 +1,49 @@
+package normalize
+
+import (
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		expr     string
+		line     string
+		expected string
+	}{
+		{`s/[0-9]+/N/`, "a 12 b 345\n", "a N b N\n"},
+		{`s|/home/[a-z]+|~|`, "/home/alice/x\n", "~/x\n"},
+		{`s/a\/b/c/`, "a/b\n", "c\n"},
+		{`s/(\w+)=(\w+)/$2=$1/`, "k=v\n", "v=k\n"},
+		{`s/abc/x/i`, "ABC abc\n", "x x\n"},
+		{`s/[0-9]+$/N/`, "status 1\r\n", "status N\r\n"},
+		{`s/^\s+|\s+$//`, "  a b \n", "a b\n"},
+		{`s/x$/y/`, "x\r", "y\r"},
+		{`s/\\/|/`, `a\b` + "\n", "a|b\n"},
+		{"timestamp", "at 2024-03-01T12:34:56.789Z ok 10:00:01\n", "at <TIMESTAMP> ok <TIMESTAMP>\n"},
+		{"timestamp", "2024-03-01 12:34:56+09:00\n", "<TIMESTAMP>\n"},
+		{"uuid", "id 123E4567-e89b-12d3-a456-426614174000.\n", "id <UUID>.\n"},
+		{"address", "ptr=0xc000012345 len=0x10\n", "ptr=<ADDRESS> len=<ADDRESS>\n"},
+		{"tmppath", "open /tmp/go-build123/a.o: /var/tmp/x\n", "open <TMPPATH>: <TMPPATH>\n"},
+	}
+	for _, tt := range tests {
+		r, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		if s := r.Apply(tt.line); s != tt.expected {
+			t.Errorf("%s: RESULT: %q EXPECTED: %q", tt.expr, s, tt.expected)
+		}
+		if r.String() != tt.expr {
+			t.Errorf("String() = %q, expected %q", r.String(), tt.expr)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, expr := range []string{"", "s", "s/a/b", "s/a", "s/a/b/x", "s/(/b/", "foo"} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("%q: error expected", expr)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// RCS delta format
+// http://www.gnu.org/software/rcs/
+//
+// A delta is a sequence of commands addressed by line numbers of the original
+// text, in increasing order:
+//
+//   dN M  delete M lines starting at line N
+//   aN M  append the following M lines after line N
+//
+// Unlike an ed script, line numbers are not affected by the preceding
+// commands.
+
+package rcs
+
+import (
+	"fmt"
+	"github.com/hattya/go.diff"
+	"strconv"
+	"strings"
+)
+
+// Delta returns the RCS delta that turns the first text into bl.
+func Delta(cl []diff.Change, bl []string) []string {
+	delta := []string{}
+	for _, c := range cl {
+		if c.Del != 0 {
+			delta = append(delta, fmt.Sprintf("d%d %d\n", c.A+1, c.Del))
+		}
+		if c.Ins != 0 {
+			delta = append(delta, fmt.Sprintf("a%d %d\n", c.A+c.Del, c.Ins))
+			delta = append(delta, bl[c.B:c.B+c.Ins]...)
+		}
+	}
+	return delta
+}
+
+// Apply applies delta to lines and returns the new text.
+func Apply(lines []string, delta []string) ([]string, error) {
+	out := []string{}
+	pos := 0
+	i := 0
+	for i < len(delta) {
+		cmd, n, m, err := parse_command(delta[i])
+		if err != nil {
+			return nil, fmt.Errorf("delta line %d: %s", i+1, err)
+		}
+		i++
+		if cmd == 'd' {
+			if n < 1 || n-1 < pos || n-1+m > len(lines) {
+				return nil, fmt.Errorf("delta line %d: line %d out of range", i, n)
+			}
+			out = append(out, lines[pos:n-1]...)
+			pos = n - 1 + m
+		} else {
+			if n < pos || n > len(lines) {
+				return nil, fmt.Errorf("delta line %d: line %d out of range", i, n)
+			}
+			if i+m > len(delta) {
+				return nil, fmt.Errorf("delta line %d: %d lines expected", i, m)
+			}
+			out = append(out, lines[pos:n]...)
+			out = append(out, delta[i:i+m]...)
+			pos = n
+			i += m
+		}
+	}
+	out = append(out, lines[pos:]...)
+	return out, nil
+}
+
+func parse_command(line string) (byte, int, int, error) {
+	line = strings.TrimSuffix(line, "\n")
+	if len(line) == 0 || (line[0] != 'a' && line[0] != 'd') {
+		return 0, 0, 0, fmt.Errorf("invalid command %q", line)
+	}
+	args := strings.Split(line[1:], " ")
+	if len(args) != 2 {
+		return 0, 0, 0, fmt.Errorf("invalid command %q", line)
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 {
+		return 0, 0, 0, fmt.Errorf("invalid command %q", line)
+	}
+	m, err := strconv.Atoi(args[1])
+	if err != nil || m < 1 {
+		return 0, 0, 0, fmt.Errorf("invalid command %q", line)
+	}
+	return line[0], n, m, nil
+}
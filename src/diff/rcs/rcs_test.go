@@ -0,0 +1,46 @@
+package rcs
+
+import (
+	"github.com/hattya/go.diff"
+	"reflect"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	al := []string{"a\n", "b\n", "c\n", "d\n", "e\n"}
+	bl := []string{"x\n", "a\n", "c\n", "y\n", "z\n", "e\n", "f"}
+	cl := []diff.Change{
+		{A: 0, B: 0, Del: 0, Ins: 1},
+		{A: 1, B: 2, Del: 1, Ins: 0},
+		{A: 3, B: 3, Del: 1, Ins: 2},
+		{A: 5, B: 6, Del: 0, Ins: 1},
+	}
+	delta := Delta(cl, bl)
+	expected := []string{"a0 1\n", "x\n", "d2 1\n", "d4 1\n", "a4 2\n", "y\n", "z\n", "a5 1\n", "f"}
+	if !reflect.DeepEqual(delta, expected) {
+		t.Fatalf("delta mismatch:\nRESULT:\n%q\nEXPECTED:\n%q", delta, expected)
+	}
+	out, err := Apply(al, delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, bl) {
+		t.Errorf("apply mismatch:\nRESULT:\n%q\nEXPECTED:\n%q", out, bl)
+	}
+}
+
+func TestApplyError(t *testing.T) {
+	al := []string{"a\n", "b\n"}
+	for _, delta := range [][]string{
+		{"d3 1\n"},
+		{"d1 3\n"},
+		{"a1 2\n", "x\n"},
+		{"d2 1\n", "d1 1\n"},
+		{"x1 1\n"},
+		{"a1\n"},
+	} {
+		if _, err := Apply(al, delta); err == nil {
+			t.Errorf("%q: error expected", delta)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Restrict a comparison to a region of a file
+//
+// A region is a range of lines, the lines between two marker lines, or the
+// lines between markers within a range of lines.  The marker lines are not
+// part of the region.  Without a line matching Begin the region is empty,
+// without a line matching End it extends to the end of the range.
+
+package region
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type Region struct {
+	// Lines Start to End, one based and inclusive.  Zero for the beginning
+	// and the end of the file.
+	Start int
+	End   int
+	Begin *regexp.Regexp
+	// Matched after the line matching Begin, or from Start without Begin.
+	EndMarker *regexp.Regexp
+}
+
+// ParseLines parses "M,N" for both files or "M,N:P,Q" for the first and
+// the second file.  M or N may be omitted for the beginning or the end of the
+// file.
+func ParseLines(spec string) (Region, Region, error) {
+	specs := strings.Split(spec, ":")
+	if len(specs) > 2 {
+		return Region{}, Region{}, fmt.Errorf("invalid line range '%s'", spec)
+	}
+	rl := []Region{}
+	for _, s := range specs {
+		r, err := parse_range(s)
+		if err != nil {
+			return Region{}, Region{}, fmt.Errorf("invalid line range '%s'", spec)
+		}
+		rl = append(rl, r)
+	}
+	if len(rl) == 1 {
+		return rl[0], rl[0], nil
+	}
+	return rl[0], rl[1], nil
+}
+
+func parse_range(s string) (Region, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != 2 {
+		return Region{}, fmt.Errorf("invalid line range '%s'", s)
+	}
+	r := Region{}
+	for i, f := range fields {
+		if f == "" {
+			continue
+		}
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 1 {
+			return Region{}, fmt.Errorf("invalid line range '%s'", s)
+		}
+		if i == 0 {
+			r.Start = n
+		} else {
+			r.End = n
+		}
+	}
+	if r.End != 0 && r.End < r.Start {
+		return Region{}, fmt.Errorf("invalid line range '%s'", s)
+	}
+	return r, nil
+}
+
+// Bounds returns the region of lines as lines[start:end].
+func (r Region) Bounds(lines []string) (int, int) {
+	start := 0
+	end := len(lines)
+	if r.Start > 1 {
+		start = min(r.Start-1, len(lines))
+	}
+	if r.End != 0 {
+		end = min(r.End, len(lines))
+	}
+	if r.Begin != nil {
+		i := find(lines[start:end], r.Begin)
+		if i == -1 {
+			return end, end
+		}
+		start += i + 1
+	}
+	if r.EndMarker != nil {
+		if i := find(lines[start:end], r.EndMarker); i != -1 {
+			end = start + i
+		}
+	}
+	return start, end
+}
+
+func find(lines []string, re *regexp.Regexp) int {
+	for i, line := range lines {
+		if re.MatchString(strings.TrimRight(line, "\r\n")) {
+			return i
+		}
+	}
+	return -1
+}
+
+func min(x int, y int) int {
+	if x < y {
+		return x
+	}
+	return y
+}
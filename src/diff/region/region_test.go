@@ -0,0 +1,56 @@
+package region
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestParseLines(t *testing.T) {
+	tests := []struct {
+		spec string
+		a, b Region
+	}{
+		{"3,5", Region{Start: 3, End: 5}, Region{Start: 3, End: 5}},
+		{"3,", Region{Start: 3}, Region{Start: 3}},
+		{",5:2,4", Region{End: 5}, Region{Start: 2, End: 4}},
+	}
+	for _, tt := range tests {
+		a, b, err := ParseLines(tt.spec)
+		if err != nil {
+			t.Errorf("%s: %v", tt.spec, err)
+		} else if a != tt.a || b != tt.b {
+			t.Errorf("%s: %+v %+v", tt.spec, a, b)
+		}
+	}
+	for _, spec := range []string{"", "3", "5,3", "0,2", "a,b", "1,2:3,4:5,6", "1,2,3"} {
+		if _, _, err := ParseLines(spec); err == nil {
+			t.Errorf("%q: error expected", spec)
+		}
+	}
+}
+
+func TestBounds(t *testing.T) {
+	lines := []string{"a\n", "BEGIN\n", "b\n", "c\n", "END\n", "d\n", "BEGIN\r\n", "e\n"}
+	begin := regexp.MustCompile("^BEGIN$")
+	end := regexp.MustCompile("^END$")
+	tests := []struct {
+		r          Region
+		start, end int
+	}{
+		{Region{}, 0, 8},
+		{Region{Start: 3, End: 4}, 2, 4},
+		{Region{Start: 7, End: 100}, 6, 8},
+		{Region{Start: 100}, 8, 8},
+		{Region{Begin: begin, EndMarker: end}, 2, 4},
+		{Region{Begin: begin}, 2, 8},
+		{Region{EndMarker: end}, 0, 4},
+		{Region{Start: 3, Begin: begin, EndMarker: end}, 7, 8},
+		{Region{End: 1, Begin: begin}, 1, 1},
+	}
+	for _, tt := range tests {
+		start, end := tt.r.Bounds(lines)
+		if start != tt.start || end != tt.end {
+			t.Errorf("%+v: [%d, %d), expected [%d, %d)", tt.r, start, end, tt.start, tt.end)
+		}
+	}
+}
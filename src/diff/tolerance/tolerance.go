@@ -0,0 +1,177 @@
+// Numeric tolerance comparison
+//
+// Two lines are equal within a tolerance when they are the same except for
+// numbers, and each pair of numbers x and y satisfies
+//
+//   |x - y| <= max(Abs, Rel * max(|x|, |y|))
+//
+// A number must begin the line or follow a character other than a letter,
+// digit, underscore, dot or sign, and must not be followed by a dot.  The
+// digits of tokens such as x1, x-1 and v1.2.3 are compared as text.  Letters
+// may follow a number, as in 5ms.
+//
+// Equality within a tolerance is not transitive, so it cannot be expressed
+// by comparison keys of each file alone.  Keys instead gives a line of the
+// second file the key of a line of the first file that it is equal to.
+
+package tolerance
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Tolerance struct {
+	Abs float64
+	Rel float64
+}
+
+var number = regexp.MustCompile(`[-+]?(?:[0-9]+(?:\.[0-9]*)?|\.[0-9]+)(?:[eE][-+]?[0-9]+)?`)
+
+// Equal reports whether x and y are equal within t.
+func (t Tolerance) Equal(x float64, y float64) bool {
+	if x == y {
+		return true
+	}
+	return math.Abs(x-y) <= math.Max(t.Abs, t.Rel*math.Max(math.Abs(x), math.Abs(y)))
+}
+
+// Split returns line with the numbers replaced by NUL, and the numbers.
+func Split(line string) (string, []float64) {
+	nums := []float64{}
+	var sb strings.Builder
+	last := 0
+	for _, m := range number.FindAllStringIndex(line, -1) {
+		if m[0] > 0 && inword(line[m[0]-1]) {
+			continue
+		} else if m[1] < len(line) && line[m[1]] == '.' {
+			continue
+		}
+		x, err := strconv.ParseFloat(line[m[0]:m[1]], 64)
+		if err != nil {
+			// Out of range, compared as text.
+			continue
+		}
+		sb.WriteString(line[last:m[0]])
+		sb.WriteByte(0)
+		nums = append(nums, x)
+		last = m[1]
+	}
+	sb.WriteString(line[last:])
+	return sb.String(), nums
+}
+
+func inword(c byte) bool {
+	return '0' <= c && c <= '9' || 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || strings.IndexByte("_.+-", c) != -1
+}
+
+type entry struct {
+	line string
+	nums []float64
+}
+
+// Lines of the first file with the same text apart from numbers, sorted by
+// the number in column col, which has the most distinct values.
+type group struct {
+	entries []entry
+	col     int
+}
+
+// The text of a line apart from numbers, and the count of numbers.  A NUL in
+// the line itself makes the text ambiguous, but not together with the count.
+type key struct {
+	s string
+	n int
+}
+
+// Keys returns bl where each line that is equal within t to a line of al is
+// replaced by that line.
+func Keys(al []string, bl []string, t Tolerance) []string {
+	exact := map[string]bool{}
+	groups := map[key]*group{}
+	for _, line := range al {
+		if exact[line] {
+			continue
+		}
+		exact[line] = true
+		s, nums := Split(line)
+		if len(nums) == 0 {
+			continue
+		}
+		k := key{s, len(nums)}
+		if groups[k] == nil {
+			groups[k] = &group{}
+		}
+		groups[k].entries = append(groups[k].entries, entry{line, nums})
+	}
+	for _, g := range groups {
+		g.index()
+	}
+	r := make([]string, len(bl))
+	for i, line := range bl {
+		r[i] = line
+		if exact[line] {
+			continue
+		}
+		s, nums := Split(line)
+		if g := groups[key{s, len(nums)}]; g != nil {
+			if e := g.find(nums, t); e != nil {
+				r[i] = e.line
+			}
+		}
+	}
+	return r
+}
+
+func (g *group) index() {
+	distinct := 0
+	for col := range g.entries[0].nums {
+		values := map[float64]bool{}
+		for _, e := range g.entries {
+			values[e.nums[col]] = true
+		}
+		if len(values) > distinct {
+			distinct = len(values)
+			g.col = col
+		}
+	}
+	sort.SliceStable(g.entries, func(i, j int) bool {
+		return g.entries[i].nums[g.col] < g.entries[j].nums[g.col]
+	})
+}
+
+// Find the first entry equal to nums within t.  Only the entries whose
+// number in column col can be equal to y are compared.
+func (g *group) find(nums []float64, t Tolerance) *entry {
+	y := nums[g.col]
+	d := t.reach(y)
+	i := sort.Search(len(g.entries), func(i int) bool { return g.entries[i].nums[g.col] >= y-d })
+	for ; i < len(g.entries) && g.entries[i].nums[g.col] <= y+d; i++ {
+		if t.equalall(g.entries[i].nums, nums) {
+			return &g.entries[i]
+		}
+	}
+	return nil
+}
+
+// The largest |x - y| of any x equal to y.  With Rel < 1,
+// |x - y| <= Rel * (|y| + |x - y|) bounds it by Rel * |y| / (1 - Rel).  It
+// is widened a little against rounding, Equal decides.
+func (t Tolerance) reach(y float64) float64 {
+	if t.Rel >= 1 {
+		return math.Inf(1)
+	}
+	return math.Max(t.Abs, t.Rel*math.Abs(y)/(1-t.Rel)) * (1 + 1e-9)
+}
+
+func (t Tolerance) equalall(xl []float64, yl []float64) bool {
+	for i := range xl {
+		if !t.Equal(xl[i], yl[i]) {
+			return false
+		}
+	}
+	return true
+}
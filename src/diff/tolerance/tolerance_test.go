@@ -0,0 +1,91 @@
+package tolerance
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		t        Tolerance
+		x, y     float64
+		expected bool
+	}{
+		{Tolerance{}, 1, 1, true},
+		{Tolerance{}, 1, 1.0000001, false},
+		{Tolerance{Abs: 1e-3}, 1, 1.0005, true},
+		{Tolerance{Abs: 1e-3}, 1, 1.002, false},
+		{Tolerance{Rel: 1e-3}, 1000, 1000.5, true},
+		{Tolerance{Rel: 1e-3}, 1, 1.002, false},
+		{Tolerance{Abs: 1e-3, Rel: 1e-6}, 0, 0.0009, true},
+		{Tolerance{Abs: 1e-3}, -1, 1, false},
+	}
+	for _, tt := range tests {
+		if r := tt.t.Equal(tt.x, tt.y); r != tt.expected {
+			t.Errorf("%+v.Equal(%v, %v) = %v", tt.t, tt.x, tt.y, r)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		line     string
+		s        string
+		expected []float64
+	}{
+		{"t=1.5e-3 x -2 .5 end\n", "t=\x00 x \x00 \x00 end\n", []float64{1.5e-3, -2, .5}},
+		{"x1 x-1 a_2 v1.2.3 1.2.3\n", "x1 x-1 a_2 v1.2.3 1.2.3\n", []float64{}},
+		{"(5ms, 10%) 3.\n", "(\x00ms, \x00%) \x00\n", []float64{5, 10, 3}},
+		{"1e999 2\n", "1e999 \x00\n", []float64{2}},
+	}
+	for _, tt := range tests {
+		s, nums := Split(tt.line)
+		if s != tt.s || !reflect.DeepEqual(nums, tt.expected) {
+			t.Errorf("Split(%q) = %q, %v", tt.line, s, nums)
+		}
+	}
+}
+
+func TestKeys(t *testing.T) {
+	al := []string{"x 1.000\n", "y 2.0 3.0\n", "text\n", "z 5\n"}
+	bl := []string{"x 1.0004\n", "y 2.0 3.1\n", "text\n", "z 5.00\n", "w 1.000\n", "x 1.000\n"}
+	expected := []string{"x 1.000\n", "y 2.0 3.1\n", "text\n", "z 5\n", "w 1.000\n", "x 1.000\n"}
+	if r := Keys(al, bl, Tolerance{Abs: 1e-3}); !reflect.DeepEqual(r, expected) {
+		t.Errorf("RESULT: %q EXPECTED: %q", r, expected)
+	}
+}
+
+func TestKeysIndex(t *testing.T) {
+	al := []string{}
+	bl := []string{}
+	for i := 0; i < 100000; i++ {
+		al = append(al, fmt.Sprintf("run 1 step %d energy %g\n", i, float64(i)*1.5))
+		bl = append(bl, fmt.Sprintf("run 1 step %d energy %g\n", i, float64(i)*1.5*(1+1e-7)))
+	}
+	bl[500] = "run 1 step 500 energy 1\n"
+	r := Keys(al, bl, Tolerance{Rel: 1e-6})
+	for i := range r {
+		if i == 500 && r[i] != bl[i] || i != 500 && r[i] != al[i] {
+			t.Fatalf("line %d: %q", i, r[i])
+		}
+	}
+	r = Keys([]string{"x 100\n", "x 200\n"}, []string{"x 199.9\n", "x 100.2\n"}, Tolerance{Abs: 0.1, Rel: 0.5})
+	if r[0] != "x 100\n" || r[1] != "x 100\n" {
+		t.Errorf("RESULT: %q", r)
+	}
+}
+
+// A NUL in a line must not mix lines with different counts of numbers.
+func TestKeysNUL(t *testing.T) {
+	al := []string{"\x00 5\n", "3 4\n"}
+	bl := []string{"\x00 5.5\n", "z\n"}
+	expected := []string{"\x00 5\n", "z\n"}
+	if r := Keys(al, bl, Tolerance{Rel: 0.5}); !reflect.DeepEqual(r, expected) {
+		t.Errorf("RESULT: %q EXPECTED: %q", r, expected)
+	}
+	expected = []string{"\x00 5.5\n", "3 4\n"}
+	if r := Keys(bl, al, Tolerance{Rel: 0.5}); !reflect.DeepEqual(r, expected) {
+		t.Errorf("RESULT: %q EXPECTED: %q", r, expected)
+	}
+}